@@ -0,0 +1,303 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// accessRoute is one gateway route a plugin serves.
+type accessRoute struct {
+	method string
+	path   string
+	body   any
+}
+
+func (r accessRoute) String() string { return r.method + " " + r.path }
+
+// accessRoutes lists every plugin-scoped route for the given IDs. IDs are
+// inserted verbatim, so callers can pass pre-escaped segments.
+func accessRoutes(pluginID, deviceID, entityID string) []accessRoute {
+	base := "/api/plugins/" + pluginID
+	dev := types.Device{ID: deviceID, LocalName: "access"}
+	ent := types.Entity{ID: entityID, DeviceID: deviceID, Domain: "switch", LocalName: "access"}
+	return []accessRoute{
+		{http.MethodGet, base + "/devices", nil},
+		{http.MethodPost, base + "/devices", dev},
+		{http.MethodPut, base + "/devices", dev},
+		{http.MethodGet, base + "/devices/" + deviceID + "/entities", nil},
+		{http.MethodPost, base + "/devices/" + deviceID + "/entities", ent},
+		{http.MethodPut, base + "/devices/" + deviceID + "/entities", ent},
+		{http.MethodPost, base + "/devices/" + deviceID + "/entities/" + entityID + "/commands", map[string]any{"type": "turn_on"}},
+		{http.MethodDelete, base + "/devices/" + deviceID + "/entities/" + entityID, nil},
+		{http.MethodDelete, base + "/devices/" + deviceID, nil},
+	}
+}
+
+func doRoute(t *testing.T, client http.Client, r accessRoute) int {
+	t.Helper()
+	var body *bytes.Reader
+	if r.body != nil {
+		data, _ := json.Marshal(r.body)
+		body = bytes.NewReader(data)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(r.method, testutil.APIBaseURL()+r.path, body)
+	if err != nil {
+		t.Fatalf("%s: build request: %v", r, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// ServeMux answers ".." paths with a redirect, and following it would
+	// turn a POST into a GET on a cleaned path: report the first response.
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s: %v", r, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestAccessPolicyUnregistered checks that every route returns 403 for a
+// plugin that never registered.
+func TestAccessPolicyUnregistered(t *testing.T) {
+	client := http.Client{Timeout: 2 * time.Second}
+	ghost := fmt.Sprintf("ghost-plugin-%d", time.Now().UnixNano())
+	for _, r := range accessRoutes(ghost, "ghost-device", "ghost-entity") {
+		if code := doRoute(t, client, r); code != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403 for unregistered plugin", r, code)
+		}
+	}
+	fmt.Println("PASS: Every route returns 403 for an unregistered plugin")
+}
+
+// TestAccessPolicyReregistered stops a harness-owned plugin and checks every
+// route then fails with one consistent status, and that the plugin and its
+// data are served again once it re-registers.
+func TestAccessPolicyReregistered(t *testing.T) {
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-test-clean"),
+		ID:     "plugin-test-clean-access",
+	})
+	client := http.Client{Timeout: 2 * time.Second}
+	const deviceID, entityID = "access-device", "access-entity"
+	routes := accessRoutes(p.ID(), deviceID, entityID)
+	// Everything but the deletes, so the device survives the cycle.
+	live := routes[:len(routes)-2]
+
+	for _, r := range live {
+		if code := doRoute(t, client, r); code >= 400 {
+			t.Fatalf("%s on registered plugin: got %d", r, code)
+		}
+	}
+
+	p.Stop()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && testutil.WaitForPlugin(p.ID(), 200*time.Millisecond) {
+		time.Sleep(200 * time.Millisecond)
+	}
+	codes := map[int][]string{}
+	for _, r := range routes {
+		code := doRoute(t, client, r)
+		codes[code] = append(codes[code], r.String())
+		if code < 400 {
+			t.Errorf("%s on stopped plugin: got %d", r, code)
+		}
+	}
+	if len(codes) > 1 {
+		t.Errorf("stopped plugin gets inconsistent status codes: %v", codes)
+	}
+
+	p.Start(t)
+	base := testutil.PluginURL(p.ID())
+	if !listContainsDevice(t, &client, base, deviceID) {
+		t.Errorf("device %s missing after plugin re-registered", deviceID)
+	}
+	for _, r := range live {
+		if code := doRoute(t, client, r); code >= 400 {
+			t.Errorf("%s after re-registration: got %d", r, code)
+		}
+	}
+	fmt.Println("PASS: Deregistered plugin routes fail consistently and recover on re-registration")
+}
+
+// carriesID reports whether route r names id in its path or body.
+func carriesID(r accessRoute, id string) bool {
+	if strings.Contains(r.path, id) {
+		return true
+	}
+	data, _ := json.Marshal(r.body)
+	return r.body != nil && strings.Contains(string(data), id)
+}
+
+// checkConsistent runs routes in order and fails if any returns a 5xx, or if
+// some accept the ID while others reject it.
+func checkConsistent(t *testing.T, client http.Client, name, kind string, routes []accessRoute) {
+	t.Helper()
+	codes := map[int][]string{}
+	for _, r := range routes {
+		code := doRoute(t, client, r)
+		if code >= 500 {
+			t.Errorf("%s: got %d", truncateRoute(r), code)
+		}
+		codes[code/100] = append(codes[code/100], fmt.Sprintf("%s=%d", truncateRoute(r), code))
+	}
+	if len(codes[2]) > 0 && len(codes[4]) > 0 {
+		t.Errorf("%s ID accepted by some %s routes and rejected by others: accepted %v, rejected %v", name, kind, codes[2], codes[4])
+	}
+}
+
+// TestAccessPolicyHostileIDs sends path traversal, encoded-slash, unicode and
+// oversized IDs through every route of a harness-owned plugin. Writes with
+// traversal IDs are always rejected; other IDs are either served or rejected
+// with a 4xx, never a 5xx, and consistently across routes; and nothing is
+// written outside the plugin's data dir.
+func TestAccessPolicyHostileIDs(t *testing.T) {
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-test-clean"),
+		ID:     "plugin-test-clean-hostile",
+	})
+	client := http.Client{Timeout: 5 * time.Second}
+	parent := filepath.Dir(p.DataDir())
+	before := listTree(t, parent)
+	// Traversal IDs name this sentinel, so a write they escape with can be
+	// found anywhere it lands, not just beside the data dir.
+	escape := fmt.Sprintf("escape-%d", time.Now().UnixNano())
+
+	traversal := []string{
+		"..",
+		"../../" + escape,
+		"%2e%2e",
+		"..%2F..%2F" + escape,
+		"a%2Fb",
+		"%2F",
+		"..%5C..%5C" + escape,
+	}
+	for _, id := range traversal {
+		t.Run("traversal "+id, func(t *testing.T) {
+			for _, r := range accessRoutes(p.ID(), id, id) {
+				code := doRoute(t, client, r)
+				if code < 400 && r.method != http.MethodGet {
+					t.Errorf("%s: got %d, want rejection", r, code)
+				}
+				if code >= 500 {
+					t.Errorf("%s: got %d", r, code)
+				}
+			}
+			// The plugin ID itself as a traversal target.
+			for _, r := range accessRoutes(p.ID()+"/"+id, "access-device", "access-entity") {
+				if code := doRoute(t, client, r); code < 400 || code >= 500 {
+					t.Errorf("%s: got %d, want 4xx", r, code)
+				}
+			}
+		})
+	}
+
+	odd := map[string]string{
+		"unicode":   "café-☕-设备",
+		"long-300":  strings.Repeat("d", 300),
+		"long-5000": strings.Repeat("d", 5000),
+	}
+	for name, id := range odd {
+		t.Run(name, func(t *testing.T) {
+			// Only routes that carry the ID say anything about it. Reads of
+			// the never-created device go first and are compared among
+			// themselves; the writes that follow must agree with each other.
+			var reads, writes []accessRoute
+			for _, r := range accessRoutes(p.ID(), id, id) {
+				switch {
+				case !carriesID(r, id):
+				case r.method == http.MethodGet:
+					reads = append(reads, r)
+				default:
+					writes = append(writes, r)
+				}
+			}
+			checkConsistent(t, client, name, "read", reads)
+			checkConsistent(t, client, name, "write", writes)
+		})
+	}
+
+	after := listTree(t, parent)
+	dataDir := p.DataDir() + string(filepath.Separator)
+	for path := range after {
+		if before[path] || path == p.DataDir() || strings.HasPrefix(path, dataDir) {
+			continue
+		}
+		t.Errorf("file written outside the data dir: %s", path)
+	}
+	for _, path := range findEscaped(t, p.DataDir(), escape) {
+		t.Errorf("file written outside the data dir: %s", path)
+	}
+	fmt.Println("PASS: Hostile IDs rejected and confined to the data dir")
+}
+
+// listTree returns every path under root.
+func listTree(t *testing.T, root string) map[string]bool {
+	t.Helper()
+	out := map[string]bool{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		out[path] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk %s: %v", root, err)
+	}
+	return out
+}
+
+// findEscaped returns entries named after the escape sentinel in every
+// ancestor of dataDir up to the filesystem root and in the working directory
+// the plugin inherits. Traversal IDs climb a few levels at most, so one level
+// of each is enough without walking the whole filesystem.
+func findEscaped(t *testing.T, dataDir, escape string) []string {
+	t.Helper()
+	dirs := []string{}
+	if wd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, wd)
+	}
+	for dir := filepath.Dir(dataDir); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	var out []string
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if strings.Contains(e.Name(), escape) {
+				out = append(out, filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	return out
+}
+
+func truncateRoute(r accessRoute) string {
+	s := r.String()
+	if len(s) > 120 {
+		return s[:120] + "..."
+	}
+	return s
+}
@@ -0,0 +1,245 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+	"github.com/slidebolt/testrunner/local/frigate"
+)
+
+// actionTarget is a harness-owned plugin whose entities the action coverage
+// suite exercises. start launches it and waits until its entities are
+// listed; skip leaves entities out, such as config entities already covered
+// by the config contract.
+type actionTarget struct {
+	name  string
+	start func(t *testing.T) *testutil.PluginProcess
+	skip  func(types.Entity) bool
+}
+
+// actionTargets are the plugins covered: the basic and lua-counter fixtures,
+// and plugin-frigate, the only one backed by an emulator (local/frigate).
+// The other hardware plugins have no emulator yet and are not exercised
+// here.
+var actionTargets = []actionTarget{
+	{
+		name: "fixture/plugin-test-clean",
+		start: func(t *testing.T) *testutil.PluginProcess {
+			fixture := testutil.FixtureDir(t, "basic")
+			p := testutil.StartPlugin(t, testutil.PluginOptions{
+				Binary:  testutil.PluginBinary("plugin-test-clean"),
+				ID:      "plugin-test-clean-actions",
+				Fixture: fixture,
+			})
+			testutil.AssertFixtureLoaded(t, p.ID(), testutil.LoadFixture(t, fixture))
+			return p
+		},
+	},
+	{
+		name: "fixture/plugin-automation",
+		start: func(t *testing.T) *testutil.PluginProcess {
+			fixture := testutil.FixtureDir(t, "lua-counter")
+			p := testutil.StartPlugin(t, testutil.PluginOptions{
+				Binary:  testutil.PluginBinary("plugin-automation"),
+				ID:      "plugin-automation-actions",
+				Fixture: fixture,
+			})
+			testutil.AssertFixtureLoaded(t, p.ID(), testutil.LoadFixture(t, fixture))
+			return p
+		},
+	},
+	{
+		name: "emulator/plugin-frigate",
+		start: func(t *testing.T) *testutil.PluginProcess {
+			const cam = "action-cam"
+			mock := testutil.StartFrigateMock(t, frigate.Camera{Name: cam, Enabled: true, StreamURL: "rtsp://mock/" + cam})
+			p := testutil.StartPlugin(t, testutil.PluginOptions{
+				Binary: testutil.PluginBinary("plugin-frigate"),
+				ID:     "plugin-frigate-actions",
+				Env:    []string{"FRIGATE_URL=" + mock.URL(), "GO2RTC_URL=" + mock.URL()},
+			})
+			waitForEntities(t, p.ID(), "frigate-device-"+cam, 10*time.Second)
+			return p
+		},
+		skip: isConfigEntity,
+	},
+}
+
+// TestActionCoverage sends every action each entity declares, with a payload
+// built from its domain descriptor, and checks the command is accepted,
+// reaches a terminal status and has a visible effect: a change to the
+// entity's reported state or, when a bus is configured, an entity event
+// caused by the command. An action the entity does not declare must be
+// rejected.
+func TestActionCoverage(t *testing.T) {
+	for _, target := range actionTargets {
+		t.Run(target.name, func(t *testing.T) {
+			p := target.start(t)
+			client := http.Client{Timeout: 3 * time.Second}
+			cases, err := testutil.ActionCases(client, p.ID(), target.skip)
+			if err != nil {
+				t.Fatalf("list action cases: %v", err)
+			}
+			if len(cases) == 0 {
+				t.Fatalf("%s exposes no entities to exercise", p.ID())
+			}
+			for _, c := range cases {
+				t.Run(c.Name(), func(t *testing.T) {
+					if !c.Declared {
+						testutil.ExpectCommandRejected(t, client, c.PluginID, c.DeviceID, c.EntityID, c.Payload, "")
+						return
+					}
+					if c.Err != nil {
+						t.Fatalf("declared action has no valid payload: %v", c.Err)
+					}
+					checkActionEffect(t, client, c)
+				})
+			}
+		})
+	}
+	fmt.Println("PASS: Every declared action accepted with an observable effect; undeclared actions rejected")
+}
+
+// checkActionEffect sends a declared action and waits for it to succeed and
+// for its effect. If the entity declares an action that undoes it, that is
+// sent first, so an action whose target state the entity is already in, such
+// as enable on an enabled camera, still has something to change. The effect
+// is the entity's reported state changing, or an event emitted after the
+// command was sent that either carries its command ID or echoes the
+// command's fields. Desired state alone does not count, since the gateway
+// records it on acknowledgement whatever the plugin does, and neither does
+// an unrelated event such as a periodic update.
+func checkActionEffect(t *testing.T, client http.Client, c testutil.ActionCase) {
+	t.Helper()
+	events := make(chan testutil.BusEvent, 16)
+	if testutil.NATSURL() != "" {
+		nc := testutil.ConnectBus(t)
+		testutil.SubscribeEntityEvents(t, nc, c.PluginID, c.DeviceID, c.EntityID, func(ev testutil.BusEvent) {
+			select {
+			case events <- ev:
+			default:
+			}
+		})
+	}
+	if c.Opposite != "" {
+		status, err := testutil.PostCommand(client, c.PluginID, c.DeviceID, c.EntityID, c.OppositePayload)
+		if err != nil {
+			t.Fatalf("send opposite %v first: %v", c.OppositePayload, err)
+		}
+		if final := testutil.WaitForCommand(t, client, status, 5*time.Second); final.State != types.CommandSucceeded {
+			t.Fatalf("opposite command %s ended %q: %s", final.CommandID, final.State, final.Error)
+		}
+		settleReported(t, client, c)
+	}
+	before := actionEntity(t, client, c)
+
+	sent := time.Now()
+	status, err := testutil.PostCommand(client, c.PluginID, c.DeviceID, c.EntityID, c.Payload)
+	if err != nil {
+		t.Fatalf("send %v: %v", c.Payload, err)
+	}
+	final := testutil.WaitForCommand(t, client, status, 5*time.Second)
+	if final.State != types.CommandSucceeded {
+		t.Fatalf("command %s ended %q: %s", final.CommandID, final.State, final.Error)
+	}
+
+	deadline := time.After(5 * time.Second)
+	var unrelated int
+	for {
+		select {
+		case ev := <-events:
+			if actionCausedEvent(ev, sent, status.CommandID, c.Payload) {
+				return
+			}
+			unrelated++
+		case <-deadline:
+			t.Fatalf("%v succeeded but reported state never changed and none of %d event(s) reflected it", c.Payload, unrelated)
+		case <-time.After(100 * time.Millisecond):
+			after := actionEntity(t, client, c)
+			if !bytes.Equal(before.Data.Reported, after.Data.Reported) {
+				return
+			}
+		}
+	}
+}
+
+// actionCausedEvent reports whether ev was emitted after sent and either
+// names commandID or echoes every field of payload other than its type.
+func actionCausedEvent(ev testutil.BusEvent, sent time.Time, commandID string, payload map[string]any) bool {
+	if ev.CreatedAt.Before(sent) {
+		return false
+	}
+	if commandID != "" && (ev.CommandID == commandID || ev.EntityEventEnvelope.CommandID == commandID) {
+		return true
+	}
+	var fields map[string]any
+	if json.Unmarshal(ev.Payload, &fields) != nil {
+		return false
+	}
+	echoed := 0
+	for k, want := range payload {
+		if k == "type" {
+			continue
+		}
+		got, ok := fields[k]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+		echoed++
+	}
+	return echoed > 0
+}
+
+// settleReported waits until the entity's reported state has stopped
+// changing for half a second, so an earlier command's effect is not mistaken
+// for the next one's.
+func settleReported(t *testing.T, client http.Client, c testutil.ActionCase) {
+	t.Helper()
+	last := actionEntity(t, client, c).Data.Reported
+	stable := time.Now()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		cur := actionEntity(t, client, c).Data.Reported
+		if !bytes.Equal(cur, last) {
+			last, stable = cur, time.Now()
+			continue
+		}
+		if time.Since(stable) >= 500*time.Millisecond {
+			return
+		}
+	}
+}
+
+func actionEntity(t *testing.T, client http.Client, c testutil.ActionCase) types.Entity {
+	t.Helper()
+	entities, err := testutil.ListEntities(client, c.PluginID, c.DeviceID)
+	if err != nil {
+		t.Fatalf("list entities: %v", err)
+	}
+	for _, e := range entities {
+		if e.ID == c.EntityID {
+			return e
+		}
+	}
+	t.Fatalf("entity %s/%s not listed", c.DeviceID, c.EntityID)
+	return types.Entity{}
+}
+
+// waitForEntities waits until a device lists at least one entity.
+func waitForEntities(t *testing.T, pluginID, deviceID string, timeout time.Duration) {
+	t.Helper()
+	client := http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if entities, err := testutil.ListEntities(client, pluginID, deviceID); err == nil && len(entities) > 0 {
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("%s/%s listed no entities within %v", pluginID, deviceID, timeout)
+}
@@ -0,0 +1,306 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestCascadeDelete builds a device with many entities, Lua scripts and
+// script state on a harness-owned plugin-automation, then deletes entities
+// one at a time and finally the device, checking that files, search results,
+// journal references and Lua subscriptions go with them.
+func TestCascadeDelete(t *testing.T) {
+	const (
+		deviceID = "cascade-device"
+		entities = 8
+	)
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-automation"),
+		ID:     "plugin-automation-cascade",
+	})
+	client := http.Client{Timeout: 3 * time.Second}
+	base := testutil.PluginURL(p.ID())
+	entitiesDir := filepath.Join(p.DataDir(), "devices", deviceID, "entities")
+
+	seedPost(t, client, base+"/devices", types.Device{ID: deviceID, LocalName: "Cascade"})
+	ids := make([]string, entities)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("cascade-entity-%02d", i)
+		seedPost(t, client, base+"/devices/"+deviceID+"/entities", types.Entity{ID: ids[i], Domain: "switch", LocalName: ids[i]})
+	}
+	// Every script subscribes to the last entity, which is never deleted, so
+	// a script that still runs after its own entity is gone is a leaked
+	// subscription.
+	trigger := ids[entities-1]
+	if err := os.MkdirAll(entitiesDir, 0o755); err != nil {
+		t.Fatalf("mkdir script dir failed: %v", err)
+	}
+	for _, id := range ids[:entities-1] {
+		script := fmt.Sprintf(`
+function OnInit(Ctx)
+  Ctx:OnCommand("%s.%s.%s.PowerOn", "DoPowerOn")
+end
+
+function DoPowerOn(Ctx, Command)
+  local c = Ctx:GetState("press_count")
+  if c == nil then c = 0 end
+  Ctx:SetState("press_count", c + 1)
+end
+`, p.ID(), deviceID, trigger)
+		if err := os.WriteFile(filepath.Join(entitiesDir, id+".lua"), []byte(script), 0o644); err != nil {
+			t.Fatalf("write script for %s: %v", id, err)
+		}
+	}
+	// Scripts load asynchronously: trigger until every one has run, which is
+	// also the positive control that subscriptions work at all.
+	scripted := ids[:entities-1]
+	deadline := time.Now().Add(10 * time.Second)
+	for !allScriptsRan(entitiesDir, scripted) {
+		if time.Now().After(deadline) {
+			t.Fatalf("not every script ran within 10s of being written")
+		}
+		cascadeCommand(t, client, p.ID(), deviceID, trigger)
+		for wait := time.Now().Add(500 * time.Millisecond); time.Now().Before(wait) && !allScriptsRan(entitiesDir, scripted); {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	t.Run("journal has the device before deletion", func(t *testing.T) {
+		nc := testutil.ConnectBus(t)
+		for _, id := range ids {
+			testutil.PublishEntityEvent(t, nc, types.EntityEventEnvelope{
+				EventID:    "cascade-" + id,
+				PluginID:   p.ID(),
+				DeviceID:   deviceID,
+				EntityID:   id,
+				EntityType: "switch",
+				Payload:    json.RawMessage(`{"type":"cascade"}`),
+				CreatedAt:  time.Now(),
+			})
+		}
+		testutil.WaitForJournal(t, client, testutil.JournalQuery{PluginID: p.ID(), DeviceID: deviceID}, 5*time.Second, func(events []testutil.JournalEvent) bool {
+			return len(events) >= entities
+		})
+	})
+
+	// Delete half the scripted entities individually.
+	deleted := ids[:entities/2]
+	for _, id := range deleted {
+		t.Run("delete entity "+id, func(t *testing.T) {
+			cascadeDelete(t, client, base+"/devices/"+deviceID+"/entities/"+id)
+			for _, suffix := range []string{".json", ".lua", ".state.lua.json"} {
+				assertGone(t, filepath.Join(entitiesDir, id+suffix))
+			}
+			list, err := testutil.ListEntities(client, p.ID(), deviceID)
+			if err != nil {
+				t.Fatalf("list entities: %v", err)
+			}
+			for _, e := range list {
+				if e.ID == id {
+					t.Errorf("deleted entity %s still listed", id)
+				}
+			}
+			found, err := testutil.SearchEntities(client, "q="+id+"&plugin_id="+p.ID())
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			for _, e := range found {
+				if e.ID == id && e.DeviceID == deviceID {
+					t.Errorf("deleted entity %s still returned by search", id)
+				}
+			}
+			assertNoJournal(t, client, testutil.JournalQuery{PluginID: p.ID(), DeviceID: deviceID, EntityID: id})
+		})
+	}
+
+	t.Run("deleted scripts no longer subscribed", func(t *testing.T) {
+		// The surviving scripts are the positive control: once each has
+		// counted the next command it has been delivered, so a deleted
+		// script still subscribed would have run by then as well.
+		survivors := ids[entities/2 : entities-1]
+		counts := map[string]float64{}
+		for _, id := range survivors {
+			counts[id] = scriptCount(filepath.Join(entitiesDir, id+".state.lua.json"))
+		}
+		cascadeCommand(t, client, p.ID(), deviceID, trigger)
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			assertDeletedScriptsIdle(t, entitiesDir, deleted)
+			counted := 0
+			for _, id := range survivors {
+				if scriptCount(filepath.Join(entitiesDir, id+".state.lua.json")) > counts[id] {
+					counted++
+				}
+			}
+			if counted == len(survivors) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("only %d of %d surviving scripts counted the next command within 5s", counted, len(survivors))
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		// Keep watching briefly for a leaked subscription that runs late.
+		for quiet := time.Now().Add(500 * time.Millisecond); time.Now().Before(quiet); time.Sleep(100 * time.Millisecond) {
+			assertDeletedScriptsIdle(t, entitiesDir, deleted)
+		}
+		assertNoOrphansSoon(t, p.DataDir())
+	})
+
+	t.Run("delete device", func(t *testing.T) {
+		cascadeDelete(t, client, base+"/devices/"+deviceID)
+		assertGone(t, filepath.Join(p.DataDir(), "devices", deviceID+".json"))
+		assertGone(t, filepath.Join(p.DataDir(), "devices", deviceID))
+		if listContainsDevice(t, &client, base, deviceID) {
+			t.Errorf("deleted device %s still listed", deviceID)
+		}
+		devices, err := testutil.SearchDevices(client, "q="+deviceID+"&plugin_id="+p.ID())
+		if err != nil {
+			t.Fatalf("search devices: %v", err)
+		}
+		if containsDeviceID(devices, deviceID) {
+			t.Errorf("deleted device %s still returned by search", deviceID)
+		}
+		found, err := testutil.SearchEntities(client, "q=cascade-entity-*&plugin_id="+p.ID())
+		if err != nil {
+			t.Fatalf("search entities: %v", err)
+		}
+		for _, e := range found {
+			if e.DeviceID == deviceID {
+				t.Errorf("entity %s of deleted device still returned by search", e.ID)
+			}
+		}
+		assertNoJournal(t, client, testutil.JournalQuery{PluginID: p.ID(), DeviceID: deviceID})
+		testutil.AssertNoOrphans(t, p.DataDir())
+	})
+	fmt.Println("PASS: Cascade delete removes files, search results, journal references and subscriptions")
+}
+
+// assertNoOrphansSoon is testutil.AssertNoOrphans with a short grace period for
+// deletes the plugin finishes asynchronously.
+func assertNoOrphansSoon(t *testing.T, dataDir string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if orphans, err := testutil.ScanOrphans(dataDir); err == nil && len(orphans) == 0 {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	testutil.AssertNoOrphans(t, dataDir)
+}
+
+// TestNoOrphansInHarnessDataDirs starts harness-owned plugins, creates a
+// device with entities on each, deletes one entity and then the device, and
+// runs the orphan scanner over the plugin's data dir before and after a
+// restart. Shared runtime data dirs are left alone: they hold whatever
+// earlier runs left behind.
+func TestNoOrphansInHarnessDataDirs(t *testing.T) {
+	for _, pluginID := range []string{"plugin-test-clean", "plugin-automation"} {
+		t.Run(pluginID, func(t *testing.T) {
+			p := testutil.StartPlugin(t, testutil.PluginOptions{
+				Binary: testutil.PluginBinary(pluginID),
+				ID:     pluginID + "-orphans",
+			})
+			client := http.Client{Timeout: 3 * time.Second}
+			base := testutil.PluginURL(p.ID())
+			const deviceID = "orphan-device"
+			seedPost(t, client, base+"/devices", types.Device{ID: deviceID, LocalName: "Orphans"})
+			for i := range 3 {
+				id := fmt.Sprintf("orphan-entity-%d", i)
+				seedPost(t, client, base+"/devices/"+deviceID+"/entities", types.Entity{ID: id, Domain: "switch", LocalName: id})
+			}
+			cascadeDelete(t, client, base+"/devices/"+deviceID+"/entities/orphan-entity-0")
+			assertNoOrphansSoon(t, p.DataDir())
+			cascadeDelete(t, client, base+"/devices/"+deviceID)
+			assertNoOrphansSoon(t, p.DataDir())
+
+			p.Stop()
+			p.Start(t)
+			testutil.AssertNoOrphans(t, p.DataDir())
+		})
+	}
+	fmt.Println("PASS: No orphaned entity directories or scripts in harness-owned data dirs")
+}
+
+func cascadeCommand(t *testing.T, client http.Client, pluginID, deviceID, entityID string) {
+	t.Helper()
+	if _, err := testutil.PostCommand(client, pluginID, deviceID, entityID, map[string]any{"type": "PowerOn"}); err != nil {
+		t.Fatalf("command %s: %v", entityID, err)
+	}
+}
+
+func cascadeDelete(t *testing.T, client http.Client, url string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE %s: expected 200, got %d", url, resp.StatusCode)
+	}
+}
+
+func assertGone(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); err == nil {
+		t.Errorf("%s still exists after delete", path)
+	}
+}
+
+func assertNoJournal(t *testing.T, client http.Client, q testutil.JournalQuery) {
+	t.Helper()
+	events, err := testutil.JournalEvents(client, q)
+	if err != nil {
+		t.Fatalf("journal query: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("journal still has %d event(s) for %s/%s/%s after delete", len(events), q.PluginID, q.DeviceID, q.EntityID)
+	}
+}
+
+// assertDeletedScriptsIdle fails t if any deleted entity's script has
+// written state, which only a still-subscribed script would do.
+func assertDeletedScriptsIdle(t *testing.T, entitiesDir string, deleted []string) {
+	t.Helper()
+	for _, id := range deleted {
+		path := filepath.Join(entitiesDir, id+".state.lua.json")
+		if _, err := os.Stat(path); err == nil {
+			t.Fatalf("script of deleted entity %s still subscribed: %s written", id, path)
+		}
+	}
+}
+
+// scriptCount returns press_count from a Lua state file, or 0.
+func scriptCount(path string) float64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	var state map[string]any
+	if json.Unmarshal(data, &state) != nil {
+		return 0
+	}
+	count, _ := state["press_count"].(float64)
+	return count
+}
+
+// allScriptsRan reports whether every entity's script has written state.
+func allScriptsRan(entitiesDir string, ids []string) bool {
+	for _, id := range ids {
+		if _, err := os.Stat(filepath.Join(entitiesDir, id+".state.lua.json")); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+// Command record-migration-fixture records an on-disk layout migration
+// fixture from a plugin-test-clean binary built against an earlier sdk-types
+// release, for TestOnDiskLayoutMigration. With the runtime up:
+//
+//	go run ./cmd/record-migration-fixture -release v1.0.0 -binary /path/to/v1.0.0/plugin-test-clean
+//
+// It writes testdata/fixtures/migrations/{release}; commit the result.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+func main() {
+	release := flag.String("release", "", "sdk-types release the binary was built with, e.g. v1.0.0")
+	binary := flag.String("binary", "", "plugin-test-clean binary built against that release")
+	flag.Parse()
+	if *release == "" || *binary == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	root, ok := testutil.FindFixtureDir("")
+	if !ok {
+		fmt.Fprintln(os.Stderr, "record-migration-fixture: no testdata/fixtures directory to record into")
+		os.Exit(1)
+	}
+	dir := filepath.Join(root, testutil.MigrationFixtures, *release)
+	if err := testutil.RecordMigration(*binary, *release, dir); err != nil {
+		fmt.Fprintf(os.Stderr, "record-migration-fixture: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("recorded sdk-types %s migration fixture in %s\n", *release, dir)
+}
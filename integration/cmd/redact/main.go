@@ -0,0 +1,37 @@
+// Command redact strips secrets from test output and reports before they are
+// stored. It learns the secrets from the process environment and the plugin
+// .env files the tests read, then either filters stdin to stdout:
+//
+//	go test -json ./... | go run ./cmd/redact > report.json
+//
+// or rewrites each file argument in place:
+//
+//	go run ./cmd/redact junit.xml reports/*.json
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+func main() {
+	if err := testutil.RegisterPluginSecrets(); err != nil {
+		fmt.Fprintf(os.Stderr, "redact: %v\n", err)
+		os.Exit(1)
+	}
+	if len(os.Args) == 1 {
+		if err := testutil.RedactStream(os.Stdout, os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "redact: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	for _, path := range os.Args[1:] {
+		if err := testutil.RedactFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "redact: %s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	entityswitch "github.com/slidebolt/sdk-entities/switch"
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// BenchmarkCommandRoundTrip measures the time from POST .../commands to the
+// resulting entity event arriving on the bus. Rates (commands per second) are
+// taken from TEST_BENCH_COMMAND_RATES, e.g. "10,50,200". Run with
+//
+//	go test -run '^$' -bench CommandRoundTrip -count 10 | tee new.txt
+//
+// and compare runs with benchstat.
+func BenchmarkCommandRoundTrip(b *testing.B) {
+	for _, pluginID := range []string{"plugin-test-clean", "plugin-test-slow"} {
+		for _, rate := range benchCommandRates(b) {
+			b.Run(fmt.Sprintf("%s/rate=%d", pluginID, rate), func(b *testing.B) {
+				benchmarkCommandRoundTrip(b, pluginID, rate)
+			})
+		}
+	}
+}
+
+func benchmarkCommandRoundTrip(b *testing.B, pluginID string, rate int) {
+	testutil.RequirePlugin(b, pluginID)
+	nc := testutil.ConnectBus(b)
+
+	const deviceID = "bench-latency-device"
+	const entityID = "bench-latency-switch"
+	client := http.Client{Timeout: 5 * time.Second}
+	base := testutil.APIBaseURL() + "/api/plugins/" + pluginID
+	benchPost(b, client, base+"/devices", types.Device{ID: deviceID, LocalName: "Latency Bench Device"})
+	benchPost(b, client, base+"/devices/"+deviceID+"/entities", types.Entity{
+		ID:      entityID,
+		Domain:  "switch",
+		Actions: []string{entityswitch.ActionTurnOn, entityswitch.ActionTurnOff},
+	})
+
+	rec := testutil.NewLatencyRecorder()
+	testutil.SubscribeEntityEvents(b, nc, pluginID, deviceID, entityID, func(ev testutil.BusEvent) {
+		rec.Observed(ev.CommandID, ev.ReceivedAt)
+	})
+
+	interval := time.Second / time.Duration(rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		<-ticker.C
+		action := entityswitch.ActionTurnOn
+		if i%2 == 1 {
+			action = entityswitch.ActionTurnOff
+		}
+		sentAt := time.Now()
+		status, err := testutil.PostCommand(client, pluginID, deviceID, entityID, entityswitch.Command{Type: action})
+		if err != nil {
+			b.Fatalf("command %d to %s failed: %v", i, pluginID, err)
+		}
+		if status.CommandID == "" {
+			b.Fatalf("command %d to %s acknowledged without a command ID", i, pluginID)
+		}
+		rec.Sent(status.CommandID, sentAt)
+	}
+	b.StopTimer()
+
+	drain := testutil.EnvDuration("TEST_BENCH_DRAIN_TIMEOUT", 5*time.Second)
+	deadline := time.Now().Add(drain)
+	for time.Now().Before(deadline) {
+		if s := rec.Summary(); s.Dropped == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s := rec.Summary()
+	b.ReportMetric(float64(s.P50)/float64(time.Millisecond), "p50-ms")
+	b.ReportMetric(float64(s.P95)/float64(time.Millisecond), "p95-ms")
+	b.ReportMetric(float64(s.P99)/float64(time.Millisecond), "p99-ms")
+	b.ReportMetric(float64(s.Dropped), "dropped")
+	b.ReportMetric(float64(s.Duplicated), "duplicated")
+	if s.Dropped > 0 || s.Duplicated > 0 {
+		b.Logf("%s rate=%d: sent=%d matched=%d dropped=%d duplicated=%d unknown=%d (drain %s)",
+			pluginID, rate, s.Sent, s.Matched, s.Dropped, s.Duplicated, s.Unknown, drain)
+	}
+}
+
+func benchPost(b *testing.B, client http.Client, url string, v any) {
+	b.Helper()
+	body, _ := json.Marshal(v)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		b.Fatalf("POST %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b.Fatalf("POST %s: expected 200, got %d", url, resp.StatusCode)
+	}
+}
+
+func benchCommandRates(b *testing.B) []int {
+	raw := strings.TrimSpace(os.Getenv("TEST_BENCH_COMMAND_RATES"))
+	if raw == "" {
+		return []int{10, 50}
+	}
+	var rates []int
+	for _, part := range strings.Split(raw, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			b.Fatalf("invalid TEST_BENCH_COMMAND_RATES entry %q", part)
+		}
+		rates = append(rates, n)
+	}
+	return rates
+}
@@ -0,0 +1,251 @@
+package integration
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+	"github.com/slidebolt/testrunner/local/frigate"
+)
+
+// configContract describes a plugin that is configured by sending a command
+// to a dedicated entity.
+type configContract struct {
+	plugin, device, entity string
+	// valid returns a payload the plugin must accept, a string that must
+	// appear in its data dir once persisted, and a check that the config is
+	// in effect on the given plugin instance.
+	valid func(t *testing.T) (payload map[string]any, marker string, applied func(t *testing.T, pluginID string))
+	// partial has one good field and one bad one, named by partialField. The
+	// whole command must be rejected.
+	partial      map[string]any
+	partialField string
+	// invalid payloads must be rejected outright.
+	invalid []any
+}
+
+var configContracts = []configContract{
+	{
+		plugin: "plugin-frigate",
+		device: "frigate-system",
+		entity: "frigate-config",
+		valid: func(t *testing.T) (map[string]any, string, func(*testing.T, string)) {
+			mock := testutil.StartFrigateMock(t, frigate.Camera{Name: "config-contract", Enabled: true, StreamURL: "rtsp://mock/config-contract"})
+			payload := map[string]any{"frigate_url": mock.URL(), "go2rtc_url": mock.URL()}
+			return payload, mock.URL(), func(t *testing.T, pluginID string) {
+				mock.ResetRequests()
+				deadline := time.Now().Add(10 * time.Second)
+				for time.Now().Before(deadline) {
+					if mock.RequestCount("/api/config") > 0 {
+						return
+					}
+					time.Sleep(200 * time.Millisecond)
+				}
+				t.Errorf("%s never polled the configured Frigate URL", pluginID)
+			}
+		},
+		partial:      map[string]any{"frigate_url": "http://127.0.0.1:5000", "go2rtc_url": "::not a url::"},
+		partialField: "go2rtc_url",
+		invalid: []any{
+			map[string]any{"frigate_url": 42},
+			map[string]any{"frigate_url": "ftp://frigate.invalid"},
+		},
+	},
+	{
+		plugin: "plugin-alexa",
+		device: "control",
+		entity: "control",
+		valid: func(t *testing.T) (map[string]any, string, func(*testing.T, string)) {
+			proxyID := fmt.Sprintf("config-contract-proxy-%d", time.Now().UnixNano())
+			payload := map[string]any{
+				"type":             "add_device",
+				"id":               proxyID,
+				"target_plugin_id": "plugin-test-clean",
+				"target_device_id": "config-contract-device",
+				"target_entity_id": "config-contract-entity",
+			}
+			return payload, proxyID, func(t *testing.T, pluginID string) {
+				client := &http.Client{Timeout: 2 * time.Second}
+				base := testutil.PluginURL(pluginID)
+				deadline := time.Now().Add(10 * time.Second)
+				for time.Now().Before(deadline) {
+					if listContainsDevice(t, client, base, proxyID) {
+						return
+					}
+					time.Sleep(200 * time.Millisecond)
+				}
+				t.Errorf("%s does not list proxy device %s", pluginID, proxyID)
+			}
+		},
+		partial: map[string]any{
+			"type":             "add_device",
+			"id":               "config-contract-partial",
+			"target_plugin_id": "plugin-test-clean",
+		},
+		partialField: "target_device_id",
+		invalid: []any{
+			map[string]any{"type": "no_such_action"},
+			map[string]any{"type": "add_device"},
+		},
+	},
+}
+
+// TestConfigEntitiesDiscovered finds the plugins that expose config
+// entities in the shared runtime, then starts a harness-owned instance of
+// each and checks that every config entity on it rejects payloads that are
+// not a JSON object, so a plugin that half-accepts one cannot break the
+// shared runtime's config. Entities without a configContract are reported so
+// they get one.
+func TestConfigEntitiesDiscovered(t *testing.T) {
+	registry, err := testutil.RegisteredPlugins()
+	if err != nil {
+		t.Fatalf("list plugins: %v", err)
+	}
+	known := map[string]bool{}
+	for _, c := range configContracts {
+		known[c.plugin+"/"+c.device+"/"+c.entity] = true
+	}
+	client := http.Client{Timeout: 2 * time.Second}
+	var pluginIDs []string
+	for pluginID := range registry {
+		if len(configEntities(client, pluginID)) > 0 {
+			pluginIDs = append(pluginIDs, pluginID)
+		}
+	}
+	sort.Strings(pluginIDs)
+	for _, pluginID := range pluginIDs {
+		t.Run(pluginID, func(t *testing.T) {
+			p := testutil.StartPlugin(t, testutil.PluginOptions{
+				Binary: testutil.PluginBinary(pluginID),
+				ID:     pluginID + "-config-discovery",
+			})
+			entities := configEntities(client, p.ID())
+			if len(entities) == 0 {
+				t.Fatalf("%s exposes no config entities, unlike the shared %s", p.ID(), pluginID)
+			}
+			for _, e := range entities {
+				key := pluginID + "/" + e.DeviceID + "/" + e.ID
+				t.Run(e.DeviceID+"/"+e.ID, func(t *testing.T) {
+					if !known[key] {
+						t.Logf("no config contract for %s; only checking malformed payloads", key)
+					}
+					for _, payload := range []any{"not an object", []any{1, 2, 3}, nil} {
+						testutil.ExpectCommandRejected(t, client, p.ID(), e.DeviceID, e.ID, payload, "")
+					}
+				})
+			}
+		})
+	}
+	fmt.Println("PASS: Config entities reject malformed payloads")
+}
+
+// configEntities lists the config entities a plugin serves, with DeviceID
+// set. Listing errors yield none.
+func configEntities(client http.Client, pluginID string) []types.Entity {
+	devices, err := testutil.ListDevices(client, pluginID)
+	if err != nil {
+		return nil
+	}
+	var out []types.Entity
+	for _, d := range devices {
+		entities, err := testutil.ListEntities(client, pluginID, d.ID)
+		if err != nil {
+			continue
+		}
+		for _, e := range entities {
+			if isConfigEntity(e) {
+				e.DeviceID = d.ID
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
+
+// TestConfigCommandContract runs each configContract against a harness-owned
+// instance: partial and invalid payloads are rejected with an error naming
+// the problem, a valid one is acknowledged, persisted to the data dir, and
+// re-applied after a restart without being sent again.
+func TestConfigCommandContract(t *testing.T) {
+	for _, c := range configContracts {
+		t.Run(c.plugin, func(t *testing.T) {
+			p := testutil.StartPlugin(t, testutil.PluginOptions{
+				Binary: testutil.PluginBinary(c.plugin),
+				ID:     c.plugin + "-config",
+			})
+			client := http.Client{Timeout: 3 * time.Second}
+
+			t.Run("invalid payloads rejected", func(t *testing.T) {
+				for _, payload := range c.invalid {
+					testutil.ExpectCommandRejected(t, client, p.ID(), c.device, c.entity, payload, "")
+				}
+			})
+
+			t.Run("partial payload rejected", func(t *testing.T) {
+				testutil.ExpectCommandRejected(t, client, p.ID(), c.device, c.entity, c.partial, c.partialField)
+			})
+
+			payload, marker, applied := c.valid(t)
+			t.Run("valid payload acknowledged and applied", func(t *testing.T) {
+				status, err := testutil.PostCommand(client, p.ID(), c.device, c.entity, payload)
+				if err != nil {
+					t.Fatalf("send config: %v", err)
+				}
+				if final := testutil.WaitForCommand(t, client, status, 5*time.Second); final.State != types.CommandSucceeded {
+					t.Fatalf("config command ended %q: %s", final.State, final.Error)
+				}
+				applied(t, p.ID())
+			})
+
+			t.Run("config persisted to data dir", func(t *testing.T) {
+				if path := findInDataDir(t, p.DataDir(), marker); path == "" {
+					t.Fatalf("no file under %s contains %q", p.DataDir(), marker)
+				}
+			})
+
+			t.Run("config re-applied after restart", func(t *testing.T) {
+				p.Stop()
+				p.Start(t)
+				applied(t, p.ID())
+			})
+		})
+	}
+	fmt.Println("PASS: Config command contract")
+}
+
+// isConfigEntity reports whether e looks like a plugin's configuration
+// entry point.
+func isConfigEntity(e types.Entity) bool {
+	return e.Domain == "config" || e.ID == "control" || strings.HasSuffix(e.ID, "-config")
+}
+
+// findInDataDir returns the first file under dir that contains needle.
+func findInDataDir(t *testing.T, dir, needle string) string {
+	t.Helper()
+	found := ""
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || found != "" {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(data), needle) {
+			found = path
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk %s: %v", dir, err)
+	}
+	return found
+}
@@ -0,0 +1,250 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestCrashConsistency kills a harness-owned plugin-test-clean instance while
+// a stream of device and entity updates is in flight, restarts it on the same
+// data dir, and checks that what was persisted is intact: every file is valid
+// JSON, no temp files are left behind, and the reloaded state is one whole
+// update that is no older than the last one acknowledged.
+func TestCrashConsistency(t *testing.T) {
+	const (
+		cycles   = 3
+		deviceID = "crash-device"
+		entityID = "crash-entity"
+	)
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-test-clean"),
+		ID:     "plugin-test-clean-crash",
+	})
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	base := testutil.APIBaseURL() + "/api/plugins/" + p.ID()
+
+	body, _ := json.Marshal(types.Device{ID: deviceID, SourceName: "s-0", LocalName: "v-0"})
+	resp, err := client.Post(base+"/devices", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("create device: %v", err)
+	}
+	resp.Body.Close()
+	body, _ = json.Marshal(types.Entity{ID: entityID, Domain: "switch", LocalName: "v-0", Actions: []string{"turn_on", "turn_off", "v-0"}})
+	resp, err = client.Post(base+"/devices/"+deviceID+"/entities", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("create entity: %v", err)
+	}
+	resp.Body.Close()
+
+	version := 0
+	for cycle := 1; cycle <= cycles; cycle++ {
+		t.Run(fmt.Sprintf("cycle %d", cycle), func(t *testing.T) {
+			stream := startUpdateStream(client, base, deviceID, entityID, version)
+			// Let a few updates land, then crash mid-stream.
+			time.Sleep(time.Duration(150*cycle) * time.Millisecond)
+			p.Kill()
+			acked, sent := stream.stop()
+			prev := version
+			version = sent
+
+			p.Start(t)
+			if acked == prev {
+				t.Fatalf("no updates were acknowledged before the crash")
+			}
+			assertDataDirClean(t, p.DataDir())
+
+			dev := readDeviceFile(t, p.DataDir(), deviceID)
+			localV := versionOf(t, "device local_name", dev.LocalName)
+			sourceV := versionOf(t, "device source_name", dev.SourceName)
+			if localV != sourceV {
+				t.Errorf("device is a mix of updates: local_name=%q source_name=%q", dev.LocalName, dev.SourceName)
+			}
+			if localV < acked {
+				t.Errorf("device lost an acknowledged update: reloaded v-%d, last acked v-%d", localV, acked)
+			}
+			if localV > sent {
+				t.Errorf("device reloaded v-%d which was never sent (last sent v-%d)", localV, sent)
+			}
+
+			ent := readEntityFile(t, p.DataDir(), deviceID, entityID)
+			entV := versionOf(t, "entity local_name", ent.LocalName)
+			if len(ent.Actions) != 3 || ent.Actions[2] != ent.LocalName {
+				t.Errorf("entity is a mix of updates: local_name=%q actions=%v", ent.LocalName, ent.Actions)
+			}
+			if entV < acked || entV > sent {
+				t.Errorf("entity reloaded v-%d outside acknowledged window [%d, %d]", entV, acked, sent)
+			}
+
+			// The restarted plugin must serve what it reloaded, not a cached
+			// or partially applied update.
+			servedDev := servedDevice(t, *client, p.ID(), deviceID)
+			if servedDev.LocalName != dev.LocalName || servedDev.SourceName != dev.SourceName {
+				t.Errorf("served device local_name=%q source_name=%q, persisted %q/%q", servedDev.LocalName, servedDev.SourceName, dev.LocalName, dev.SourceName)
+			}
+			if v := versionOf(t, "served device local_name", servedDev.LocalName); v < acked || v > sent {
+				t.Errorf("served device v-%d outside acknowledged window [%d, %d]", v, acked, sent)
+			}
+			servedEnt := servedEntity(t, *client, p.ID(), deviceID, entityID)
+			if servedEnt.LocalName != ent.LocalName || len(servedEnt.Actions) != 3 || servedEnt.Actions[2] != servedEnt.LocalName {
+				t.Errorf("served entity local_name=%q actions=%v, persisted local_name=%q", servedEnt.LocalName, servedEnt.Actions, ent.LocalName)
+			}
+			if v := versionOf(t, "served entity local_name", servedEnt.LocalName); v < acked || v > sent {
+				t.Errorf("served entity v-%d outside acknowledged window [%d, %d]", v, acked, sent)
+			}
+			fmt.Printf("PASS: cycle %d reloaded v-%d (acked %d, sent %d)\n", cycle, localV, acked, sent)
+		})
+	}
+}
+
+// updateStream sends sequential device and entity PUTs, each carrying an
+// increasing version in both the local and source fields so a torn write is
+// detectable.
+type updateStream struct {
+	mu    sync.Mutex
+	acked int
+	sent  int
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func startUpdateStream(client *http.Client, base, deviceID, entityID string, from int) *updateStream {
+	s := &updateStream{acked: from, sent: from, quit: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for v := from + 1; ; v++ {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			tag := "v-" + strconv.Itoa(v)
+			s.mu.Lock()
+			s.sent = v
+			s.mu.Unlock()
+
+			devErr := racePut(client, base+"/devices", types.Device{ID: deviceID, SourceName: "s-" + strconv.Itoa(v), LocalName: tag})
+			entErr := racePut(client, base+"/devices/"+deviceID+"/entities", types.Entity{ID: entityID, Domain: "switch", LocalName: tag, Actions: []string{"turn_on", "turn_off", tag}})
+			if devErr != nil || entErr != nil {
+				// The plugin is gone; keep going until told to stop so that
+				// requests racing the kill are still counted as sent.
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			s.mu.Lock()
+			s.acked = v
+			s.mu.Unlock()
+		}
+	}()
+	return s
+}
+
+func (s *updateStream) stop() (acked, sent int) {
+	close(s.quit)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acked, s.sent
+}
+
+// assertDataDirClean fails if any file under dir is not valid JSON, except
+// Lua scripts, or looks like a temp file from an interrupted write.
+func assertDataDirClean(t *testing.T, dir string) {
+	t.Helper()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := d.Name()
+		switch {
+		case strings.HasSuffix(name, ".lua"):
+			return nil
+		case strings.HasSuffix(name, ".json") && !strings.HasPrefix(name, "."):
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if !json.Valid(data) {
+				t.Errorf("invalid JSON after crash: %s: %q", path, truncate(data, 200))
+			}
+		default:
+			t.Errorf("leftover temp file after crash: %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk %s: %v", dir, err)
+	}
+}
+
+func versionOf(t *testing.T, field, value string) int {
+	t.Helper()
+	i := strings.LastIndex(value, "-")
+	n, err := strconv.Atoi(value[i+1:])
+	if i < 0 || err != nil {
+		t.Fatalf("%s %q is not a version written by this test", field, value)
+	}
+	return n
+}
+
+func servedDevice(t *testing.T, client http.Client, pluginID, deviceID string) types.Device {
+	t.Helper()
+	devices, err := testutil.ListDevices(client, pluginID)
+	if err != nil {
+		t.Fatalf("list devices: %v", err)
+	}
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return d
+		}
+	}
+	t.Fatalf("device %q not listed after restart", deviceID)
+	return types.Device{}
+}
+
+func servedEntity(t *testing.T, client http.Client, pluginID, deviceID, entityID string) types.Entity {
+	t.Helper()
+	entities, err := testutil.ListEntities(client, pluginID, deviceID)
+	if err != nil {
+		t.Fatalf("list entities: %v", err)
+	}
+	for _, e := range entities {
+		if e.ID == entityID {
+			return e
+		}
+	}
+	t.Fatalf("entity %s/%s not listed after restart", deviceID, entityID)
+	return types.Entity{}
+}
+
+func listContainsDevice(t *testing.T, client *http.Client, base, deviceID string) bool {
+	t.Helper()
+	resp, err := client.Get(base + "/devices")
+	if err != nil {
+		t.Fatalf("list devices: %v", err)
+	}
+	defer resp.Body.Close()
+	var devices []types.Device
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		t.Fatalf("decode devices: %v", err)
+	}
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestFixtureSeededDevicesLoad starts a plugin against a pre-populated data
+// dir and checks everything in the snapshot is served without having been
+// created through the API.
+func TestFixtureSeededDevicesLoad(t *testing.T) {
+	fixture := testutil.FixtureDir(t, "basic")
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary:  testutil.PluginBinary("plugin-test-clean"),
+		ID:      "plugin-test-clean-fixture",
+		Fixture: fixture,
+	})
+
+	fx := testutil.LoadFixture(t, fixture)
+	if len(fx.Devices) == 0 {
+		t.Fatalf("fixture %s has no devices", fixture)
+	}
+	testutil.AssertFixtureLoaded(t, p.ID(), fx)
+	fmt.Printf("PASS: %d fixture device(s) loaded by %s\n", len(fx.Devices), p.ID())
+}
+
+// TestFixtureSeededLuaState checks that a seeded script is picked up and that
+// it resumes from its seeded .state.lua.json rather than starting over.
+func TestFixtureSeededLuaState(t *testing.T) {
+	const (
+		deviceID = "fixture-lua-device"
+		entityID = "fixture-lua-switch"
+	)
+	fixture := testutil.FixtureDir(t, "lua-counter")
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary:  testutil.PluginBinary("plugin-automation"),
+		ID:      "plugin-automation-fixture",
+		Fixture: fixture,
+	})
+	testutil.AssertFixtureLoaded(t, p.ID(), testutil.LoadFixture(t, fixture))
+
+	client := http.Client{Timeout: 3 * time.Second}
+	if _, err := testutil.PostCommand(client, p.ID(), deviceID, entityID, map[string]any{"type": "PowerOn"}); err != nil {
+		t.Fatalf("post command: %v", err)
+	}
+
+	statePath := filepath.Join(p.DataDir(), "devices", deviceID, "entities", entityID+".state.lua.json")
+	deadline := time.Now().Add(5 * time.Second)
+	var count float64
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(statePath); err == nil {
+			var state map[string]any
+			if json.Unmarshal(data, &state) == nil {
+				count, _ = state["press_count"].(float64)
+				if count == 6 {
+					fmt.Println("PASS: seeded Lua state resumed from press_count=5")
+					return
+				}
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("press_count = %v, want 6 (seeded 5 + one command)", count)
+}
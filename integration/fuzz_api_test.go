@@ -0,0 +1,207 @@
+package integration
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+const (
+	fuzzPluginID = "plugin-test-clean"
+	fuzzDeviceID = "fuzz-device"
+	fuzzEntityID = "fuzz-entity"
+)
+
+// fuzzMalformed are payload shapes every target is seeded with: wrong types,
+// huge arrays, deep nesting, nulls and duplicate keys.
+var fuzzMalformed = []string{
+	`{invalid-json}`,
+	``,
+	`null`,
+	`[]`,
+	`"device"`,
+	`{"id": 42}`,
+	`{"id": null, "labels": null}`,
+	`{"id": ["a", "b"]}`,
+	`{"id": "dup", "id": "dup-2"}`,
+	`{"id": "x", "labels": {"room": {"nested": true}}}`,
+	`{"id": "x", "actions": [` + strings.Repeat(`"a",`, 20000) + `"a"]}`,
+	strings.Repeat(`{"a":`, 5000) + `1` + strings.Repeat(`}`, 5000),
+	`{"id": "` + strings.Repeat("x", 1<<16) + `"}`,
+	`{"id": "\u0000"}`,
+}
+
+// FuzzDevicePayload sends arbitrary bodies to the device routes.
+func FuzzDevicePayload(f *testing.F) {
+	for _, v := range []any{
+		types.Device{ID: fuzzDeviceID, SourceName: "fuzz", LocalName: "fuzz"},
+		types.Device{ID: fuzzDeviceID, Labels: map[string]string{"room": "fuzz"}},
+	} {
+		data, _ := json.Marshal(v)
+		for m := range uint8(3) {
+			f.Add(m, data)
+		}
+	}
+	fuzzAPI(f, func(pluginID string, method uint8, body []byte) (string, string) {
+		base := testutil.PluginURL(pluginID) + "/devices"
+		switch method % 3 {
+		case 0:
+			return http.MethodPost, base
+		case 1:
+			return http.MethodPut, base
+		default:
+			var dev struct {
+				ID string `json:"id"`
+			}
+			_ = json.Unmarshal(body, &dev)
+			if dev.ID == "" || dev.ID == fuzzDeviceID {
+				dev.ID = "fuzz-delete"
+			}
+			return http.MethodDelete, base + "/" + url.PathEscape(dev.ID)
+		}
+	})
+}
+
+// FuzzEntityPayload sends arbitrary bodies to the entity routes.
+func FuzzEntityPayload(f *testing.F) {
+	for _, v := range []any{
+		types.Entity{ID: fuzzEntityID, DeviceID: fuzzDeviceID, Domain: "switch", LocalName: "fuzz", Actions: []string{"turn_on", "turn_off"}},
+		types.Entity{ID: fuzzEntityID, DeviceID: fuzzDeviceID, Domain: "", Labels: map[string]string{"": ""}},
+	} {
+		data, _ := json.Marshal(v)
+		for m := range uint8(3) {
+			f.Add(m, data)
+		}
+	}
+	fuzzAPI(f, func(pluginID string, method uint8, body []byte) (string, string) {
+		base := testutil.PluginURL(pluginID) + "/devices/" + fuzzDeviceID + "/entities"
+		switch method % 3 {
+		case 0:
+			return http.MethodPost, base
+		case 1:
+			return http.MethodPut, base
+		default:
+			return http.MethodDelete, base + "/fuzz-delete"
+		}
+	})
+}
+
+// FuzzCommandPayload sends arbitrary bodies to the command route.
+func FuzzCommandPayload(f *testing.F) {
+	for _, seed := range []string{`{"type":"turn_on"}`, `{"type":"turn_off"}`, `{"type":"toggle","extra":[1,2,3]}`, `{"type":""}`} {
+		f.Add(uint8(0), []byte(seed))
+	}
+	fuzzAPI(f, func(pluginID string, _ uint8, _ []byte) (string, string) {
+		return http.MethodPost, testutil.CommandURL(pluginID, fuzzDeviceID, fuzzEntityID)
+	})
+}
+
+// fuzzAPI seeds the malformed corpus, starts a harness-owned instance of
+// fuzzPluginID on a temp data dir, makes sure the fixed fuzz device and entity
+// exist on it, and fuzzes the route picked by route. Each input must get a
+// non-5xx response and leave the plugin healthy. A 4xx must leave the data
+// dir untouched; anything else may only write valid JSON.
+func fuzzAPI(f *testing.F, route func(pluginID string, method uint8, body []byte) (string, string)) {
+	p := testutil.StartPlugin(f, testutil.PluginOptions{
+		Binary: testutil.PluginBinary(fuzzPluginID),
+		ID:     fuzzPluginID + "-fuzz",
+	})
+	pluginID, dataDir := p.ID(), p.DataDir()
+	for _, seed := range fuzzMalformed {
+		for m := range uint8(3) {
+			f.Add(m, []byte(seed))
+		}
+	}
+
+	client := http.Client{Timeout: 5 * time.Second}
+	base := testutil.PluginURL(pluginID)
+	seedPost(f, client, base+"/devices", types.Device{ID: fuzzDeviceID, LocalName: "fuzz"})
+	seedPost(f, client, base+"/devices/"+fuzzDeviceID+"/entities", types.Entity{ID: fuzzEntityID, DeviceID: fuzzDeviceID, Domain: "switch", LocalName: "fuzz", Actions: []string{"turn_on", "turn_off", "toggle"}})
+
+	f.Fuzz(func(t *testing.T, method uint8, body []byte) {
+		verb, target := route(pluginID, method, body)
+		req, err := http.NewRequest(verb, target, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		before := snapshotDir(t, dataDir)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: no response to %d-byte body %q: %v (plugin healthy: %v)",
+				verb, target, len(body), truncate(body, 200), err, testutil.WaitForPlugin(pluginID, 2*time.Second))
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			t.Fatalf("%s %s returned %d for body %q", verb, target, resp.StatusCode, truncate(body, 200))
+		}
+		if !testutil.WaitForPlugin(pluginID, 2*time.Second) {
+			t.Fatalf("%s %s: plugin unhealthy after body %q", verb, target, truncate(body, 200))
+		}
+		changed := changedFiles(before, snapshotDir(t, dataDir))
+		if resp.StatusCode >= http.StatusBadRequest && len(changed) > 0 {
+			t.Fatalf("%s %s returned %d but changed %v for body %q", verb, target, resp.StatusCode, changed, truncate(body, 200))
+		}
+		for _, path := range changed {
+			data, err := os.ReadFile(path)
+			if err != nil || !strings.HasSuffix(path, ".json") {
+				continue
+			}
+			if !json.Valid(data) {
+				t.Fatalf("invalid JSON persisted to %s after body %q: %q", path, truncate(body, 200), truncate(data, 200))
+			}
+		}
+	})
+}
+
+// snapshotDir returns the SHA-256 of every file under dir, keyed by path.
+func snapshotDir(t *testing.T, dir string) map[string][sha256.Size]byte {
+	t.Helper()
+	files := map[string][sha256.Size]byte{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			var data []byte
+			if data, err = os.ReadFile(path); err == nil {
+				files[path] = sha256.Sum256(data)
+			}
+		}
+		if os.IsNotExist(err) {
+			return nil // removed by a write in progress
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("snapshot %s: %v", dir, err)
+	}
+	return files
+}
+
+// changedFiles returns the paths added, removed or modified between two
+// snapshots, sorted.
+func changedFiles(before, after map[string][sha256.Size]byte) []string {
+	var paths []string
+	for path, sum := range after {
+		if prev, ok := before[path]; !ok || prev != sum {
+			paths = append(paths, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
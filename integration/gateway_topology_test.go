@@ -0,0 +1,303 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestGatewayTopologyConsistent starts two gateways on the shared bus and
+// checks a client sees the same plugin registry, search results, command
+// status and journal whichever one it talks to.
+func TestGatewayTopologyConsistent(t *testing.T) {
+	var gateways []string
+	for _, g := range testutil.StartGateways(t, 2) {
+		gateways = append(gateways, g.URL())
+	}
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-test-clean"),
+		ID:     "plugin-test-clean-multigw",
+	})
+	client := http.Client{Timeout: 3 * time.Second}
+	nonce := time.Now().UnixNano()
+	deviceID := fmt.Sprintf("multigw-device-%d", nonce)
+	entityID := fmt.Sprintf("multigw-switch-%d", nonce)
+
+	// Seed through the second gateway so the first only sees it via the bus.
+	base := testutil.OnGateway(gateways[1], testutil.PluginURL(p.ID()))
+	seedPost(t, client, base+"/devices", types.Device{ID: deviceID, LocalName: "Multi Gateway"})
+	seedPost(t, client, base+"/devices/"+deviceID+"/entities", types.Entity{ID: entityID, Domain: "switch", Actions: []string{"turn_on", "turn_off"}})
+
+	t.Run("registration", func(t *testing.T) {
+		eventually(t, 5*time.Second, func() error {
+			var want []string
+			for i, gw := range gateways {
+				var registry map[string]types.Registration
+				if err := gatewayGet(client, testutil.OnGateway(gw, testutil.APIBaseURL()+"/api/plugins"), &registry); err != nil {
+					return fmt.Errorf("%s: %w", gw, err)
+				}
+				if _, ok := registry[p.ID()]; !ok {
+					return fmt.Errorf("%s does not list %s", gw, p.ID())
+				}
+				ids := slices.Sorted(maps.Keys(registry))
+				if i == 0 {
+					want = ids
+				} else if !slices.Equal(ids, want) {
+					return fmt.Errorf("%s lists %v, %s lists %v", gateways[0], want, gw, ids)
+				}
+			}
+			return nil
+		})
+	})
+
+	t.Run("search", func(t *testing.T) {
+		for _, gw := range gateways {
+			eventually(t, 5*time.Second, func() error {
+				var devices []types.Device
+				if err := gatewayGet(client, testutil.OnGateway(gw, testutil.SearchURL("devices", "q="+deviceID)), &devices); err != nil {
+					return fmt.Errorf("%s: %w", gw, err)
+				}
+				if !containsDeviceID(devices, deviceID) {
+					return fmt.Errorf("%s search does not find %s", gw, deviceID)
+				}
+				return nil
+			})
+		}
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		for _, gw := range gateways {
+			status := gatewayCommand(t, client, gw, p.ID(), deviceID, entityID, map[string]any{"type": "turn_on"})
+			eventually(t, 10*time.Second, func() error {
+				var states []types.CommandState
+				for _, other := range gateways {
+					var s types.CommandStatus
+					if err := gatewayGet(client, testutil.OnGateway(other, testutil.CommandStatusURL(p.ID(), status.CommandID)), &s); err != nil {
+						return fmt.Errorf("%s status of %s: %w", other, status.CommandID, err)
+					}
+					states = append(states, s.State)
+				}
+				for _, s := range states {
+					if s != types.CommandSucceeded {
+						return fmt.Errorf("command %s sent via %s has states %v across gateways", status.CommandID, gw, states)
+					}
+				}
+				return nil
+			})
+		}
+	})
+
+	t.Run("journal", func(t *testing.T) {
+		nc := testutil.ConnectBus(t)
+		eventID := fmt.Sprintf("multigw-event-%d", nonce)
+		testutil.PublishEntityEvent(t, nc, types.EntityEventEnvelope{
+			EventID:    eventID,
+			PluginID:   p.ID(),
+			DeviceID:   deviceID,
+			EntityID:   entityID,
+			EntityType: "switch",
+			Payload:    json.RawMessage(`{"type":"multigw"}`),
+			CreatedAt:  time.Now(),
+		})
+		q := testutil.JournalQuery{PluginID: p.ID(), DeviceID: deviceID}
+		for _, gw := range gateways {
+			eventually(t, 5*time.Second, func() error {
+				var events []testutil.JournalEvent
+				if err := gatewayGet(client, testutil.OnGateway(gw, testutil.JournalURL(q)), &events); err != nil {
+					return fmt.Errorf("%s: %w", gw, err)
+				}
+				for _, ev := range events {
+					if ev.EventID == eventID {
+						return nil
+					}
+				}
+				return fmt.Errorf("%s journal has no %s", gw, eventID)
+			})
+		}
+	})
+	fmt.Println("PASS: Registry, search, commands and journal consistent across gateways")
+}
+
+// TestGatewayFailover starts two gateways on the shared bus, routes a client
+// and commands through the second, then kills that gateway while commands
+// sent through both are still in flight on the slow plugin. The first must
+// be unaffected: a client polling it throughout sees no errors, the plugin
+// stays registered and healthy, every in-flight command finishes, including
+// those the killed gateway acknowledged, and new commands are accepted.
+func TestGatewayFailover(t *testing.T) {
+	gateways := testutil.StartGateways(t, 2)
+	primary, doomed := gateways[0].URL(), gateways[1]
+	doomedURL := doomed.URL()
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-test-slow"),
+		ID:     "plugin-test-slow-failover",
+	})
+	client := http.Client{Timeout: 3 * time.Second}
+	const (
+		deviceID = "failover-device"
+		entityID = "failover-switch"
+	)
+
+	// The doomed gateway's client sets up and drives the entity.
+	doomedBase := testutil.OnGateway(doomedURL, testutil.PluginURL(p.ID()))
+	seedPost(t, client, doomedBase+"/devices", types.Device{ID: deviceID, LocalName: "Failover"})
+	seedPost(t, client, doomedBase+"/devices/"+deviceID+"/entities", types.Entity{ID: entityID, Domain: "switch", Actions: []string{"turn_on", "turn_off"}})
+	var inflight []types.CommandStatus
+	for _, gw := range []string{doomedURL, primary, doomedURL, primary} {
+		inflight = append(inflight, gatewayCommand(t, client, gw, p.ID(), deviceID, entityID, map[string]any{"type": "turn_on"}))
+	}
+
+	// A client of the primary polls it across the kill.
+	stop := make(chan struct{})
+	done := make(chan []error)
+	go func() {
+		var errs []error
+		polls := 0
+		for {
+			select {
+			case <-stop:
+				if polls == 0 {
+					errs = append(errs, fmt.Errorf("primary never polled"))
+				}
+				done <- errs
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+			polls++
+			var entities []types.Entity
+			if err := gatewayGet(client, testutil.OnGateway(primary, testutil.PluginURL(p.ID())+"/devices/"+deviceID+"/entities"), &entities); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}()
+
+	// The kill only proves something while commands are still running.
+	pending := 0
+	for _, status := range inflight {
+		var s types.CommandStatus
+		if err := gatewayGet(client, testutil.OnGateway(primary, testutil.CommandStatusURL(p.ID(), status.CommandID)), &s); err != nil {
+			t.Fatalf("status of %s: %v", status.CommandID, err)
+		}
+		if s.State == types.CommandPending {
+			pending++
+		}
+	}
+	if pending == 0 {
+		t.Fatalf("every in-flight command finished before %s was killed; the slow plugin is not slow enough", doomedURL)
+	}
+
+	doomed.Kill()
+
+	eventually(t, 5*time.Second, func() error {
+		var health map[string]string
+		if err := gatewayGet(client, testutil.OnGateway(primary, testutil.PluginHealthURL(p.ID())), &health); err != nil {
+			return fmt.Errorf("%s health on %s after %s was killed: %w", p.ID(), primary, doomedURL, err)
+		}
+		if health["status"] != "perfect" {
+			return fmt.Errorf("%s reports %q on %s after %s was killed", p.ID(), health["status"], primary, doomedURL)
+		}
+		return nil
+	})
+	var registry map[string]types.Registration
+	if err := gatewayGet(client, testutil.OnGateway(primary, testutil.APIBaseURL()+"/api/plugins"), &registry); err != nil {
+		t.Fatalf("list plugins: %v", err)
+	}
+	if _, ok := registry[p.ID()]; !ok {
+		t.Errorf("%s dropped from %s's registry", p.ID(), primary)
+	}
+	for i, status := range inflight {
+		via := primary
+		if i%2 == 0 {
+			via = doomedURL
+		}
+		if final := gatewayWaitCommand(t, client, primary, status, 15*time.Second); final.State != types.CommandSucceeded {
+			t.Errorf("in-flight command %s sent via %s ended %q after failover: %s", final.CommandID, via, final.State, final.Error)
+		}
+	}
+	status := gatewayCommand(t, client, primary, p.ID(), deviceID, entityID, map[string]any{"type": "turn_off"})
+	if final := gatewayWaitCommand(t, client, primary, status, 15*time.Second); final.State != types.CommandSucceeded {
+		t.Errorf("command after failover ended %q: %s", final.State, final.Error)
+	}
+
+	close(stop)
+	for _, err := range <-done {
+		t.Errorf("client of %s affected by the failover: %v", primary, err)
+	}
+	fmt.Println("PASS: Killing one gateway left the other's clients, registrations and in-flight commands intact")
+}
+
+// gatewayCommand is testutil.PostCommand against a specific gateway.
+func gatewayCommand(t *testing.T, client http.Client, gateway, pluginID, deviceID, entityID string, payload any) types.CommandStatus {
+	t.Helper()
+	body, _ := json.Marshal(payload)
+	url := testutil.OnGateway(gateway, testutil.CommandURL(pluginID, deviceID, entityID))
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST %s: expected 202, got %d", url, resp.StatusCode)
+	}
+	var status types.CommandStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("decode command status: %v", err)
+	}
+	return status
+}
+
+// gatewayWaitCommand is testutil.WaitForCommand against a specific gateway.
+func gatewayWaitCommand(t *testing.T, client http.Client, gateway string, status types.CommandStatus, timeout time.Duration) types.CommandStatus {
+	t.Helper()
+	url := testutil.OnGateway(gateway, testutil.CommandStatusURL(status.PluginID, status.CommandID))
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if status.State != types.CommandPending && status.State != "" {
+			return status
+		}
+		time.Sleep(100 * time.Millisecond)
+		var next types.CommandStatus
+		if err := gatewayGet(client, url, &next); err == nil {
+			status = next
+		}
+	}
+	t.Fatalf("command %s still %q on %s after %s", status.CommandID, status.State, gateway, timeout)
+	return status
+}
+
+func gatewayGet(client http.Client, url string, v any) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// eventually retries check until it returns nil, failing with its last error
+// on timeout.
+func eventually(t *testing.T, timeout time.Duration, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -6,8 +6,11 @@ require (
 	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/slidebolt/sdk-runner v1.1.0
 	github.com/slidebolt/sdk-types v1.1.0
+	github.com/slidebolt/testrunner/local v0.0.0-00010101000000-000000000000
 )
 
+replace github.com/slidebolt/testrunner/local => ../local
+
 require github.com/yuin/gopher-lua v1.1.1 // indirect
 
 require (
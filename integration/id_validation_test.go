@@ -0,0 +1,195 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// idCase is one candidate ID. Legal IDs must be stored verbatim; illegal
+// ones must be rejected with a 4xx or normalized to a legal ID.
+type idCase struct {
+	name  string
+	id    string
+	legal bool
+}
+
+func idCases(nonce string) []idCase {
+	// A MAC address, as plugin-kasa uses for device IDs.
+	m := nonce[len(nonce)-8:]
+	mac := "AA:BB:" + m[0:2] + ":" + m[2:4] + ":" + m[4:6] + ":" + m[6:8]
+	return []idCase{
+		{"lowercase", "idv-" + nonce, true},
+		{"underscore", "idv_" + nonce, true},
+		{"leading digit", "0idv-" + nonce, true},
+		{"uppercase", "IDV-" + nonce, true},
+		{"mac", mac, true},
+		{"max length", "idv-" + nonce + strings.Repeat("x", 128-len("idv-"+nonce)), true},
+		{"space", "idv " + nonce, false},
+		{"dots", "idv." + nonce + ".x", false},
+		{"slash", "idv/" + nonce, false},
+		{"leading dash", "-idv-" + nonce, false},
+		{"too long", "idv-" + nonce + strings.Repeat("x", 129-len("idv-"+nonce)), false},
+		{"empty", "", false},
+	}
+}
+
+// TestIDValidation checks the accepted ID grammar (testutil.IDPattern) for
+// device and entity IDs on plugin-test-clean: legal IDs round-trip through
+// the gateway, the on-disk file names and search; illegal ones are rejected
+// or normalized to a legal ID everywhere, and case variants never produce
+// two distinct objects.
+func TestIDValidation(t *testing.T) {
+	const pluginID = "plugin-test-clean"
+	testutil.RequirePlugin(t, pluginID)
+	dataDir := testutil.PluginDataDir(pluginID)
+	client := http.Client{Timeout: 3 * time.Second}
+	nonce := fmt.Sprintf("%d", time.Now().UnixNano())
+	base := testutil.PluginURL(pluginID)
+	parentID := "idv-parent-" + nonce
+	seedPost(t, client, base+"/devices", types.Device{ID: parentID, LocalName: "ID Parent"})
+
+	for _, c := range idCases(nonce) {
+		t.Run("device "+c.name, func(t *testing.T) {
+			sourceID := "idv-src-" + nonce + "-" + strings.ReplaceAll(c.name, " ", "-")
+			code := idPost(t, client, base+"/devices", types.Device{ID: c.id, SourceID: sourceID, LocalName: "ID " + c.name})
+			var stored string
+			devices, err := testutil.ListDevices(client, pluginID)
+			if err != nil {
+				t.Fatalf("list devices: %v", err)
+			}
+			for _, d := range devices {
+				if d.SourceID == sourceID {
+					stored = d.ID
+				}
+			}
+			checkStoredID(t, c, code, stored)
+			if stored == "" {
+				return
+			}
+			if dataDir != "" {
+				assertIDFile(t, filepath.Join(dataDir, "devices"), c, stored)
+			}
+			found, err := testutil.SearchDevices(client, "q="+url.QueryEscape(stored))
+			if err != nil {
+				t.Fatalf("search: %v", err)
+			}
+			if !containsDeviceID(found, stored) {
+				t.Errorf("search q=%s does not return stored device %q", stored, stored)
+			}
+		})
+
+		t.Run("entity "+c.name, func(t *testing.T) {
+			localName := "ID entity " + c.name + " " + nonce
+			code := idPost(t, client, base+"/devices/"+parentID+"/entities", types.Entity{ID: c.id, Domain: "switch", LocalName: localName})
+			var stored string
+			entities, err := testutil.ListEntities(client, pluginID, parentID)
+			if err != nil {
+				t.Fatalf("list entities: %v", err)
+			}
+			for _, e := range entities {
+				if e.LocalName == localName {
+					stored = e.ID
+				}
+			}
+			checkStoredID(t, c, code, stored)
+			if stored != "" && dataDir != "" {
+				assertIDFile(t, filepath.Join(dataDir, "devices", parentID, "entities"), c, stored)
+			}
+		})
+	}
+
+	t.Run("case variants", func(t *testing.T) {
+		lower := "idv-case-" + nonce
+		seedPost(t, client, base+"/devices", types.Device{ID: lower, LocalName: "lower"})
+		// Upper case is legal, but a case variant of an existing ID must be
+		// rejected or resolve to the same object.
+		if code := idPost(t, client, base+"/devices", types.Device{ID: strings.ToUpper(lower), LocalName: "upper"}); code >= 500 {
+			t.Fatalf("create case variant: got %d", code)
+		}
+		devices, err := testutil.ListDevices(client, pluginID)
+		if err != nil {
+			t.Fatalf("list devices: %v", err)
+		}
+		var variants []string
+		for _, d := range devices {
+			if strings.EqualFold(d.ID, lower) {
+				variants = append(variants, d.ID)
+			}
+		}
+		if len(variants) != 1 {
+			t.Errorf("case variants of %q stored as %d objects: %v", lower, len(variants), variants)
+		}
+	})
+	fmt.Println("PASS: ID grammar enforced at the gateway, on disk and in search")
+}
+
+// checkStoredID applies the grammar rules to one create attempt: code is the
+// gateway's status and stored the ID it was listed under ("" if none).
+func checkStoredID(t *testing.T, c idCase, code int, stored string) {
+	t.Helper()
+	switch {
+	case code >= 500:
+		t.Fatalf("create %q: got %d", c.id, code)
+	case c.legal && code >= 300:
+		t.Fatalf("legal ID %q rejected with %d", c.id, code)
+	case c.legal && stored != c.id:
+		t.Fatalf("legal ID %q stored as %q", c.id, stored)
+	case !c.legal && code >= 400:
+		if stored != "" {
+			t.Errorf("illegal ID %q rejected with %d but stored as %q", c.id, code, stored)
+		}
+	case !c.legal && stored == c.id:
+		t.Errorf("illegal ID %q stored verbatim", c.id)
+	case !c.legal && stored == "":
+		t.Errorf("illegal ID %q accepted with %d but stored under no ID", c.id, code)
+	}
+	if stored != "" && !testutil.ValidID(stored) {
+		t.Errorf("ID %q stored as %q, which does not match %s", c.id, stored, testutil.IDPattern())
+	}
+}
+
+// assertIDFile checks that stored has a file in dir and that the raw ID, if
+// it differs, does not.
+func assertIDFile(t *testing.T, dir string, c idCase, stored string) {
+	t.Helper()
+	if _, err := os.Stat(filepath.Join(dir, stored+".json")); err != nil {
+		t.Errorf("no file for stored ID %q in %s: %v", stored, dir, err)
+	}
+	if c.id == stored || c.id == "" || strings.ContainsAny(c.id, `/\`) {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dir, c.id+".json")); err == nil {
+		t.Errorf("file written under raw ID %q as well as normalized %q", c.id, stored)
+	}
+}
+
+func idPost(t *testing.T, client http.Client, url string, v any) int {
+	t.Helper()
+	body, _ := json.Marshal(v)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func containsDeviceID(devices []types.Device, id string) bool {
+	for _, d := range devices {
+		if d.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+	"github.com/slidebolt/testrunner/local/frigate"
+)
+
+// implicitEntry is one way an entity can arrive for a device the plugin does
+// not have. introduce triggers it on p and returns the device and entity IDs.
+type implicitEntry struct {
+	name      string
+	plugin    string
+	env       func(t *testing.T) []string
+	introduce func(t *testing.T, client http.Client, p *testutil.PluginProcess) (deviceID, entityID string)
+}
+
+func implicitEntries() []implicitEntry {
+	var mock *frigate.Server
+	return []implicitEntry{
+		{
+			name:   "http create",
+			plugin: "plugin-test-clean",
+			introduce: func(t *testing.T, client http.Client, p *testutil.PluginProcess) (string, string) {
+				const deviceID, entityID = "implicit-create-device", "implicit-create-entity"
+				body, _ := json.Marshal(types.Entity{ID: entityID, Domain: "switch", LocalName: "Implicit Create"})
+				resp, err := client.Post(testutil.PluginURL(p.ID())+"/devices/"+deviceID+"/entities", "application/json", bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("create entity: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("create entity under unknown device: got %d, want 200", resp.StatusCode)
+				}
+				return deviceID, entityID
+			},
+		},
+		{
+			name:   "http update",
+			plugin: "plugin-test-clean",
+			introduce: func(t *testing.T, client http.Client, p *testutil.PluginProcess) (string, string) {
+				const deviceID, entityID = "implicit-update-device", "implicit-update-entity"
+				putEntity(t, &client, testutil.PluginURL(p.ID()), deviceID, types.Entity{ID: entityID, Domain: "switch", LocalName: "Implicit Update"})
+				return deviceID, entityID
+			},
+		},
+		{
+			name:   "lua emit",
+			plugin: "plugin-automation",
+			introduce: func(t *testing.T, client http.Client, p *testutil.PluginProcess) (string, string) {
+				const (
+					hostDevice = "implicit-lua-host"
+					hostEntity = "implicit-lua-switch"
+					deviceID   = "implicit-lua-device"
+					entityID   = "implicit-lua-entity"
+				)
+				base := testutil.PluginURL(p.ID())
+				seedPost(t, client, base+"/devices", types.Device{ID: hostDevice, LocalName: "Implicit Lua Host"})
+				seedPost(t, client, base+"/devices/"+hostDevice+"/entities", types.Entity{ID: hostEntity, Domain: "switch", LocalName: "Implicit Lua Switch"})
+
+				dir := filepath.Join(p.DataDir(), "devices", hostDevice, "entities")
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatalf("mkdir script dir failed: %v", err)
+				}
+				script := fmt.Sprintf(`
+function OnInit(Ctx)
+  Ctx:OnCommand("%s.%s.%s.PowerOn", "DoPowerOn")
+end
+
+function DoPowerOn(Ctx, Command)
+  Ctx:EmitEvent({DeviceID="%s", EntityID="%s", Payload={type="implicit"}})
+  Ctx:SetState("emitted", true)
+end
+`, p.ID(), hostDevice, hostEntity, deviceID, entityID)
+				if err := os.WriteFile(filepath.Join(dir, hostEntity+".lua"), []byte(script), 0o644); err != nil {
+					t.Fatalf("write script failed: %v", err)
+				}
+				commandUntilScripted(t, client, p.ID(), hostDevice, hostEntity, filepath.Join(dir, hostEntity+".state.lua.json"), 10*time.Second)
+				return deviceID, entityID
+			},
+		},
+		{
+			// Discovery of a camera the plugin already knows, after its
+			// device was deleted: the next pass brings the entity for a
+			// device the store no longer has.
+			name:   "rediscovery after delete",
+			plugin: "plugin-frigate",
+			env: func(t *testing.T) []string {
+				mock = testutil.StartFrigateMock(t, frigate.Camera{Name: "implicit-cam", Enabled: true, StreamURL: "rtsp://mock/implicit-cam"})
+				return []string{"FRIGATE_URL=" + mock.URL(), "GO2RTC_URL=" + mock.URL()}
+			},
+			introduce: func(t *testing.T, client http.Client, p *testutil.PluginProcess) (string, string) {
+				const deviceID, entityID = "frigate-device-implicit-cam", "frigate-entity-implicit-cam"
+				waitListed(t, client, p.ID(), deviceID, true, 10*time.Second)
+
+				// Hold discovery off while the device is deleted, so the
+				// deletion is observed before the camera comes back.
+				mock.InjectFault("*", frigate.Fault{Status: http.StatusServiceUnavailable})
+				req, _ := http.NewRequest(http.MethodDelete, testutil.PluginURL(p.ID())+"/devices/"+deviceID, nil)
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("delete device: %v", err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("delete device: got %d, want 200", resp.StatusCode)
+				}
+				waitListed(t, client, p.ID(), deviceID, false, 5*time.Second)
+				if _, err := os.Stat(filepath.Join(p.DataDir(), "devices", deviceID+".json")); err == nil {
+					t.Fatalf("device file for %s survived delete", deviceID)
+				}
+				mock.ClearFaults()
+				return deviceID, entityID
+			},
+		},
+	}
+}
+
+// TestImplicitDeviceCreation checks every path that can introduce an entity
+// for an unknown device behaves the same way: the device is auto-created
+// with defaults and holds the entity, both are written to disk, and the
+// device is visible in the device list and in search, both immediately and
+// after the plugin restarts.
+func TestImplicitDeviceCreation(t *testing.T) {
+	for _, entry := range implicitEntries() {
+		t.Run(entry.name, func(t *testing.T) {
+			opts := testutil.PluginOptions{
+				Binary: testutil.PluginBinary(entry.plugin),
+				ID:     entry.plugin + "-implicit",
+			}
+			if entry.env != nil {
+				opts.Env = entry.env(t)
+			}
+			p := testutil.StartPlugin(t, opts)
+			client := http.Client{Timeout: 3 * time.Second}
+
+			deviceID, entityID := entry.introduce(t, client, p)
+			assertImplicitDevice(t, client, p, deviceID, entityID)
+
+			p.Stop()
+			p.Start(t)
+			t.Run("after restart", func(t *testing.T) {
+				assertImplicitDevice(t, client, p, deviceID, entityID)
+			})
+		})
+	}
+	fmt.Println("PASS: Unknown devices are auto-created the same way on every entry point")
+}
+
+// assertImplicitDevice waits for deviceID to be listed with entityID under
+// it, and checks both are on disk and the device is found by search.
+func assertImplicitDevice(t *testing.T, client http.Client, p *testutil.PluginProcess, deviceID, entityID string) {
+	t.Helper()
+	dev := waitListed(t, client, p.ID(), deviceID, true, 10*time.Second)
+	// Discovery may supply a source name; nothing else may invent one.
+	if dev.SourceName == "" && dev.LocalName != "" && dev.LocalName != deviceID {
+		t.Errorf("implicit device %q defaulted local name to %q, want empty or the device ID", deviceID, dev.LocalName)
+	}
+
+	var entities []types.Entity
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var err error
+		entities, err = testutil.ListEntities(client, p.ID(), deviceID)
+		if err == nil && containsEntityID(entities, entityID) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entity %q not listed under implicit device %q (err %v, got %v)", entityID, deviceID, err, entities)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	persisted := readDeviceFile(t, p.DataDir(), deviceID)
+	if persisted.ID != deviceID {
+		t.Errorf("device file ID %q, want %q", persisted.ID, deviceID)
+	}
+	if ent := readEntityFile(t, p.DataDir(), deviceID, entityID); ent.ID != entityID {
+		t.Errorf("entity file ID %q, want %q", ent.ID, entityID)
+	}
+
+	found, err := testutil.SearchDevices(client, "q="+deviceID)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if !containsDeviceID(found, deviceID) {
+		t.Errorf("implicit device %q not returned by search", deviceID)
+	}
+}
+
+// waitListed polls the plugin's device list until deviceID is listed, or no
+// longer listed when want is false, and returns the listed device.
+func waitListed(t *testing.T, client http.Client, pluginID, deviceID string, want bool, timeout time.Duration) types.Device {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		devices, err := testutil.ListDevices(client, pluginID)
+		if err != nil {
+			t.Fatalf("list devices: %v", err)
+		}
+		for _, d := range devices {
+			if d.ID == deviceID && want {
+				return d
+			}
+		}
+		if !want && !containsDeviceID(devices, deviceID) {
+			return types.Device{}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("device %q listed=%v not reached within %v", deviceID, want, timeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func containsEntityID(entities []types.Entity, id string) bool {
+	for _, e := range entities {
+		if e.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// commandUntilScripted sends PowerOn to a scripted entity until its script
+// writes statePath, since a new script is loaded asynchronously. Every
+// command must succeed.
+func commandUntilScripted(t *testing.T, client http.Client, pluginID, deviceID, entityID, statePath string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := testutil.PostCommand(client, pluginID, deviceID, entityID, map[string]any{"type": "PowerOn"})
+		if err != nil {
+			t.Fatalf("command %s/%s: %v", deviceID, entityID, err)
+		}
+		if final := testutil.WaitForCommand(t, client, status, 5*time.Second); final.State != types.CommandSucceeded {
+			t.Fatalf("command %s on %s/%s ended %s: %s", final.CommandID, deviceID, entityID, final.State, final.Error)
+		}
+		for wait := time.Now().Add(500 * time.Millisecond); time.Now().Before(wait); time.Sleep(100 * time.Millisecond) {
+			if _, err := os.Stat(statePath); err == nil {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("script state %s not written within %v", statePath, timeout)
+		}
+	}
+}
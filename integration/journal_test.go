@@ -0,0 +1,288 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	entityswitch "github.com/slidebolt/sdk-entities/switch"
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+const journalPluginID = "plugin-test-clean"
+
+// publishJournalEvents publishes n events for one entity, each with a unique
+// EventID derived from prefix, and returns the IDs in publish order.
+func publishJournalEvents(t *testing.T, pub func(types.EntityEventEnvelope), prefix, deviceID, entityID string, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := 0; i < n; i++ {
+		ids[i] = fmt.Sprintf("%s-%06d", prefix, i)
+		pub(types.EntityEventEnvelope{
+			EventID:    ids[i],
+			PluginID:   journalPluginID,
+			DeviceID:   deviceID,
+			EntityID:   entityID,
+			EntityType: "switch",
+			Payload:    json.RawMessage(fmt.Sprintf(`{"type":"journal-test","seq":%d}`, i)),
+			CreatedAt:  time.Now(),
+		})
+	}
+	return ids
+}
+
+func journalIDs(events []testutil.JournalEvent) map[string]bool {
+	out := make(map[string]bool, len(events))
+	for _, e := range events {
+		out[e.EventID] = true
+	}
+	return out
+}
+
+func assertJournalOrdered(t *testing.T, events []testutil.JournalEvent) {
+	t.Helper()
+	for i := 1; i < len(events); i++ {
+		if events[i].CreatedAt.Before(events[i-1].CreatedAt) {
+			t.Errorf("journal out of order at %d: %s (%s) before %s (%s)", i,
+				events[i-1].EventID, events[i-1].CreatedAt.Format(time.RFC3339Nano),
+				events[i].EventID, events[i].CreatedAt.Format(time.RFC3339Nano))
+			return
+		}
+	}
+}
+
+func TestJournalFiltering(t *testing.T) {
+	testutil.RequirePlugin(t, journalPluginID)
+	nc := testutil.ConnectBus(t)
+	pub := func(ev types.EntityEventEnvelope) { testutil.PublishEntityEvent(t, nc, ev) }
+	client := http.Client{Timeout: 5 * time.Second}
+
+	nonce := time.Now().UnixNano()
+	devA := fmt.Sprintf("journal-dev-a-%d", nonce)
+	devB := fmt.Sprintf("journal-dev-b-%d", nonce)
+
+	early := publishJournalEvents(t, pub, fmt.Sprintf("jf-%d-a1", nonce), devA, "ent-1", 10)
+	publishJournalEvents(t, pub, fmt.Sprintf("jf-%d-a2", nonce), devA, "ent-2", 10)
+	publishJournalEvents(t, pub, fmt.Sprintf("jf-%d-b1", nonce), devB, "ent-1", 10)
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	mid := time.Now()
+	time.Sleep(100 * time.Millisecond)
+	late := publishJournalEvents(t, pub, fmt.Sprintf("jf-%d-a1-late", nonce), devA, "ent-1", 10)
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	all := testutil.WaitForJournal(t, client, testutil.JournalQuery{PluginID: journalPluginID, DeviceID: devA}, 10*time.Second,
+		func(events []testutil.JournalEvent) bool { return len(events) >= 30 })
+
+	t.Run("device_id filter", func(t *testing.T) {
+		if len(all) != 30 {
+			t.Errorf("device %s: got %d entries, want 30", devA, len(all))
+		}
+		for _, e := range all {
+			if e.DeviceID != devA || e.PluginID != journalPluginID {
+				t.Errorf("entry %s leaked through filter: plugin=%s device=%s", e.EventID, e.PluginID, e.DeviceID)
+			}
+		}
+		assertJournalOrdered(t, all)
+	})
+
+	t.Run("entity_id filter", func(t *testing.T) {
+		events, err := testutil.JournalEvents(client, testutil.JournalQuery{PluginID: journalPluginID, DeviceID: devA, EntityID: "ent-1"})
+		if err != nil {
+			t.Fatalf("journal query: %v", err)
+		}
+		if len(events) != 20 {
+			t.Errorf("got %d entries for %s/ent-1, want 20", len(events), devA)
+		}
+		for _, e := range events {
+			if e.EntityID != "ent-1" {
+				t.Errorf("entry %s has entity %q, want ent-1", e.EventID, e.EntityID)
+			}
+		}
+		assertJournalOrdered(t, events)
+	})
+
+	t.Run("entity_id filter does not cross devices", func(t *testing.T) {
+		events, err := testutil.JournalEvents(client, testutil.JournalQuery{PluginID: journalPluginID, DeviceID: devB, EntityID: "ent-1"})
+		if err != nil {
+			t.Fatalf("journal query: %v", err)
+		}
+		if len(events) != 10 {
+			t.Errorf("got %d entries for %s/ent-1, want 10", len(events), devB)
+		}
+	})
+
+	t.Run("since", func(t *testing.T) {
+		events, err := testutil.JournalEvents(client, testutil.JournalQuery{PluginID: journalPluginID, DeviceID: devA, EntityID: "ent-1", Since: mid})
+		if err != nil {
+			t.Fatalf("journal query: %v", err)
+		}
+		got := journalIDs(events)
+		for _, id := range late {
+			if !got[id] {
+				t.Errorf("event %s published after since=%s missing", id, mid.Format(time.RFC3339Nano))
+			}
+		}
+		for _, id := range early {
+			if got[id] {
+				t.Errorf("event %s published before since=%s returned", id, mid.Format(time.RFC3339Nano))
+			}
+		}
+	})
+
+	t.Run("until", func(t *testing.T) {
+		events, err := testutil.JournalEvents(client, testutil.JournalQuery{PluginID: journalPluginID, DeviceID: devA, EntityID: "ent-1", Until: mid})
+		if err != nil {
+			t.Fatalf("journal query: %v", err)
+		}
+		got := journalIDs(events)
+		for _, id := range early {
+			if !got[id] {
+				t.Errorf("event %s published before until=%s missing", id, mid.Format(time.RFC3339Nano))
+			}
+		}
+		for _, id := range late {
+			if got[id] {
+				t.Errorf("event %s published after until=%s returned", id, mid.Format(time.RFC3339Nano))
+			}
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		events, err := testutil.JournalEvents(client, testutil.JournalQuery{PluginID: journalPluginID, DeviceID: devA, Limit: 5})
+		if err != nil {
+			t.Fatalf("journal query: %v", err)
+		}
+		if len(events) != 5 {
+			t.Fatalf("limit=5 returned %d entries", len(events))
+		}
+		// The window must be a contiguous run of the unlimited result.
+		start := -1
+		for i, e := range all {
+			if e.EventID == events[0].EventID {
+				start = i
+				break
+			}
+		}
+		if start < 0 || start+5 > len(all) {
+			t.Fatalf("limit=5 window %s.. not found in full result", events[0].EventID)
+		}
+		for i, e := range events {
+			if all[start+i].EventID != e.EventID {
+				t.Errorf("limit=5 entry %d is %s, want %s", i, e.EventID, all[start+i].EventID)
+			}
+		}
+	})
+	fmt.Println("PASS: journal filters, ordering and limit verified")
+}
+
+// TestJournalMatchesBus checks that every event a plugin emits on the bus in
+// response to commands ends up in the journal.
+func TestJournalMatchesBus(t *testing.T) {
+	testutil.RequirePlugin(t, journalPluginID)
+	nc := testutil.ConnectBus(t)
+	client := http.Client{Timeout: 5 * time.Second}
+
+	deviceID := fmt.Sprintf("journal-bus-dev-%d", time.Now().UnixNano())
+	const entityID = "journal-bus-switch"
+	seedPost(t, client, testutil.PluginURL(journalPluginID)+"/devices", types.Device{ID: deviceID})
+	seedPost(t, client, testutil.PluginURL(journalPluginID)+"/devices/"+deviceID+"/entities", types.Entity{ID: entityID, Domain: "switch"})
+
+	var (
+		mu     sync.Mutex
+		onBus  []string
+		sentAt = time.Now()
+	)
+	testutil.SubscribeEntityEvents(t, nc, journalPluginID, deviceID, entityID, func(ev testutil.BusEvent) {
+		mu.Lock()
+		onBus = append(onBus, ev.EventID)
+		mu.Unlock()
+	})
+	for i := 0; i < 20; i++ {
+		action := entityswitch.ActionTurnOn
+		if i%2 == 1 {
+			action = entityswitch.ActionTurnOff
+		}
+		if _, err := testutil.PostCommand(client, journalPluginID, deviceID, entityID, entityswitch.Command{Type: action}); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+	time.Sleep(time.Second)
+	mu.Lock()
+	busIDs := append([]string(nil), onBus...)
+	mu.Unlock()
+	if len(busIDs) == 0 {
+		t.Fatalf("no bus events observed for %s/%s after 20 commands", deviceID, entityID)
+	}
+
+	q := testutil.JournalQuery{PluginID: journalPluginID, DeviceID: deviceID, EntityID: entityID, Since: sentAt.Add(-time.Second)}
+	events := testutil.WaitForJournal(t, client, q, 10*time.Second, func(events []testutil.JournalEvent) bool {
+		return len(events) >= len(busIDs)
+	})
+	got := journalIDs(events)
+	for _, id := range busIDs {
+		if !got[id] {
+			t.Errorf("bus event %s missing from journal", id)
+		}
+	}
+	if len(events) > len(busIDs) {
+		t.Errorf("journal has %d entries but only %d events were seen on the bus", len(events), len(busIDs))
+	}
+	assertJournalOrdered(t, events)
+	fmt.Printf("PASS: %d bus event(s) all present in journal\n", len(busIDs))
+}
+
+// TestJournalRetentionUnderBurst publishes a burst of events for one entity
+// and checks that whatever the journal keeps is the newest contiguous run:
+// truncation may drop old entries but must never leave gaps or lose the
+// most recent ones.
+func TestJournalRetentionUnderBurst(t *testing.T) {
+	testutil.RequirePlugin(t, journalPluginID)
+	nc := testutil.ConnectBus(t)
+	pub := func(ev types.EntityEventEnvelope) { testutil.PublishEntityEvent(t, nc, ev) }
+	client := http.Client{Timeout: 10 * time.Second}
+
+	const burst = 5000
+	nonce := time.Now().UnixNano()
+	deviceID := fmt.Sprintf("journal-burst-dev-%d", nonce)
+	ids := publishJournalEvents(t, pub, fmt.Sprintf("jb-%d", nonce), deviceID, "burst", burst)
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	last := ids[len(ids)-1]
+	q := testutil.JournalQuery{PluginID: journalPluginID, DeviceID: deviceID}
+	events := testutil.WaitForJournal(t, client, q, 30*time.Second, func(events []testutil.JournalEvent) bool {
+		return len(events) > 0 && events[len(events)-1].EventID == last
+	})
+
+	index := make(map[string]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	first, ok := index[events[0].EventID]
+	if !ok {
+		t.Fatalf("journal returned unknown event %s", events[0].EventID)
+	}
+	if first+len(events) > burst {
+		t.Fatalf("journal returned %d entries starting at %d, more than were published", len(events), first)
+	}
+	for i, e := range events {
+		if want := ids[first+i]; e.EventID != want {
+			t.Fatalf("gap in retained journal at %d: got %s, want %s", i, e.EventID, want)
+		}
+	}
+	if first+len(events) != burst {
+		t.Errorf("retained window ends at %d, want %d", first+len(events), burst)
+	}
+	assertJournalOrdered(t, events)
+	fmt.Printf("PASS: journal retained newest %d of %d burst events without gaps\n", len(events), burst)
+}
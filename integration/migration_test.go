@@ -0,0 +1,273 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestOnDiskLayoutMigration starts the current plugin-test-clean against
+// every recorded data dir from an earlier sdk-types release and checks that
+// it serves what that release served, defaults every field the release did
+// not know, and rewrites each file in the current layout and encoding.
+func TestOnDiskLayoutMigration(t *testing.T) {
+	root, ok := testutil.FindFixtureDir(testutil.MigrationFixtures)
+	if !ok {
+		t.Skip("no recorded migration fixtures; record them with cmd/record-migration-fixture")
+	}
+	releases, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("read %s: %v", root, err)
+	}
+	current := moduleVersion("github.com/slidebolt/sdk-types")
+	ran := 0
+	for _, entry := range releases {
+		if !entry.IsDir() {
+			continue
+		}
+		var rec testutil.MigrationRecording
+		if data, err := os.ReadFile(filepath.Join(root, entry.Name(), "release.json")); err != nil || json.Unmarshal(data, &rec) != nil {
+			t.Errorf("migration fixture %s has no valid release.json", entry.Name())
+			continue
+		}
+		if rec.SDKTypes == current {
+			continue
+		}
+		ran++
+		t.Run("sdk-types "+rec.SDKTypes, func(t *testing.T) {
+			runMigration(t, filepath.Join(root, entry.Name(), "data"), rec)
+		})
+	}
+	if ran == 0 {
+		t.Skipf("no migration fixtures recorded from a release before %s", current)
+	}
+}
+
+func runMigration(t *testing.T, fixture string, rec testutil.MigrationRecording) {
+	dataDir := t.TempDir()
+	testutil.SeedDataDir(t, fixture, dataDir)
+	// Backdate the seeded files so a rewrite is visible in their modtime.
+	seeded := time.Now().Add(-time.Hour)
+	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, seeded, seeded)
+	})
+	if err != nil {
+		t.Fatalf("backdate seeded files: %v", err)
+	}
+
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary:  testutil.PluginBinary("plugin-test-clean"),
+		ID:      "plugin-test-clean-migrate",
+		DataDir: dataDir,
+	})
+	client := http.Client{Timeout: 3 * time.Second}
+
+	want := testutil.Fixture{Entities: map[string][]types.Entity{}}
+	for id, raw := range rec.Devices {
+		var dev types.Device
+		if err := json.Unmarshal(raw, &dev); err != nil {
+			t.Fatalf("recorded device %s: %v", id, err)
+		}
+		want.Devices = append(want.Devices, dev)
+		assertDefaulted(t, "device "+id, raw, servedDevice(t, client, p.ID(), id), nil)
+	}
+	for deviceID, entities := range rec.Entities {
+		for id, raw := range entities {
+			var ent types.Entity
+			if err := json.Unmarshal(raw, &ent); err != nil {
+				t.Fatalf("recorded entity %s/%s: %v", deviceID, id, err)
+			}
+			if ent.DeviceID == "" {
+				ent.DeviceID = deviceID
+			}
+			want.Entities[deviceID] = append(want.Entities[deviceID], ent)
+			assertDefaulted(t, "entity "+deviceID+"/"+id, raw, servedEntity(t, client, p.ID(), deviceID, id), map[string]any{"device_id": deviceID})
+		}
+	}
+	// Everything the release served must be served unchanged.
+	testutil.AssertFixtureLoaded(t, p.ID(), want)
+
+	var current []string
+	for _, dev := range want.Devices {
+		current = append(current, filepath.Join(dataDir, "devices", dev.ID+".json"))
+	}
+	for deviceID, entities := range want.Entities {
+		for _, ent := range entities {
+			current = append(current, filepath.Join(dataDir, "devices", deviceID, "entities", ent.ID+".json"))
+		}
+	}
+	for _, path := range current {
+		waitForRewrite(t, path, seeded, 5*time.Second)
+	}
+	assertCurrentFormat[types.Device](t, current[:len(want.Devices)]...)
+	assertCurrentFormat[types.Entity](t, current[len(want.Devices):]...)
+	assertNoLegacyFiles(t, dataDir, current)
+	fmt.Printf("PASS: sdk-types %s data dir migrated\n", rec.SDKTypes)
+}
+
+// assertDefaulted checks every field of the current type that the release
+// did not serve: it must hold the value in defaults, keyed by JSON name, or
+// be empty.
+func assertDefaulted(t *testing.T, what string, recorded json.RawMessage, served any, defaults map[string]any) {
+	t.Helper()
+	var old map[string]json.RawMessage
+	_ = json.Unmarshal(recorded, &old)
+	data, _ := json.Marshal(served)
+	var now map[string]any
+	_ = json.Unmarshal(data, &now)
+	for _, key := range jsonKeys(reflect.TypeOf(served)) {
+		if _, known := old[key]; known {
+			continue
+		}
+		want, ok := defaults[key]
+		got := now[key]
+		switch {
+		case ok && !reflect.DeepEqual(got, want):
+			t.Errorf("%s: field %q unknown to the release defaulted to %v, want %v", what, key, got, want)
+		case !ok && !isEmptyJSON(got):
+			t.Errorf("%s: field %q unknown to the release defaulted to %v, want empty", what, key, got)
+		}
+	}
+}
+
+func isEmptyJSON(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		for _, e := range v {
+			if !isEmptyJSON(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// jsonKeys lists the JSON names of a struct type's fields.
+func jsonKeys(typ reflect.Type) []string {
+	var keys []string
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = typ.Field(i).Name
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+func waitForRewrite(t *testing.T, path string, seeded time.Time, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(seeded.Add(time.Minute)) {
+			return
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				t.Errorf("not rewritten in current layout: %v", err)
+			} else {
+				t.Errorf("%s not rewritten: modtime %v is still the seeded one", path, info.ModTime())
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// assertCurrentFormat fails unless each path holds exactly what the current
+// T would encode, with no key T does not define.
+func assertCurrentFormat[T any](t *testing.T, paths ...string) {
+	t.Helper()
+	var zero T
+	known := jsonKeys(reflect.TypeOf(zero))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue // reported by waitForRewrite
+		}
+		var onDisk map[string]any
+		if err := json.Unmarshal(data, &onDisk); err != nil {
+			t.Errorf("%s invalid JSON: %v", path, err)
+			continue
+		}
+		for key := range onDisk {
+			if !slices.Contains(known, key) {
+				t.Errorf("%s still has legacy key %q", path, key)
+			}
+		}
+		var typed T
+		if err := json.Unmarshal(data, &typed); err != nil {
+			t.Errorf("%s does not decode as %T: %v", path, typed, err)
+			continue
+		}
+		reencoded, _ := json.Marshal(typed)
+		var current map[string]any
+		_ = json.Unmarshal(reencoded, &current)
+		if !reflect.DeepEqual(onDisk, current) {
+			t.Errorf("%s not in current encoding:\n on disk: %s\n current: %s", path, data, reencoded)
+		}
+	}
+}
+
+// assertNoLegacyFiles fails for any device or entity JSON left outside the
+// current devices/{id}.json and devices/{id}/entities/{id}.json layout.
+func assertNoLegacyFiles(t *testing.T, dataDir string, current []string) {
+	t.Helper()
+	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".state.lua.json") {
+			return err
+		}
+		if !slices.Contains(current, path) {
+			t.Errorf("legacy file left behind: %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk %s: %v", dataDir, err)
+	}
+}
+
+// moduleVersion returns the version of a dependency the test binary was
+// built with, or "" if unknown.
+func moduleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return dep.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+const (
+	raceWriters = 16 // goroutines per side (source and local)
+	raceRounds  = 10 // PUTs per goroutine
+)
+
+// TestNameWalledGardenConcurrent repeats the TestNameWalledGarden merge
+// invariants under interleaved concurrent PUTs. Source writers and local
+// writers hammer the same device/entity; whatever order the runner applies
+// them in, the persisted JSON must keep both sides and each side's fields must
+// come from a single write.
+func TestNameWalledGardenConcurrent(t *testing.T) {
+	const pluginID = "plugin-test-clean"
+	testutil.RequirePlugin(t, pluginID)
+
+	dataDir := testutil.PluginDataDir(pluginID)
+	if dataDir == "" {
+		t.Fatal("could not locate plugin data directory")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	base := testutil.APIBaseURL() + "/api/plugins/" + pluginID
+
+	t.Run("device: concurrent source and local_name updates", func(t *testing.T) {
+		const id = "wg-race-device"
+		seedPost(t, *client, base+"/devices", types.Device{ID: id, SourceID: "seed-source", SourceName: "seed source name", LocalName: "seed local name"})
+
+		deviceFile := filepath.Join(dataDir, "devices", id+".json")
+		writes, errs := runRace(t, deviceFile, func(side, tag string) error {
+			if side == "source" {
+				return racePut(client, base+"/devices", types.Device{ID: id, SourceID: "src-" + tag, SourceName: "Source " + tag})
+			}
+			return racePut(client, base+"/devices", types.Device{ID: id, LocalName: "Local " + tag})
+		})
+		for _, err := range errs {
+			t.Error(err)
+		}
+
+		dev := readDeviceFile(t, dataDir, id)
+		checkLastWrite(t, "local_name", dev.LocalName, "Local ", writes["local"])
+		if tag, ok := checkLastWrite(t, "source_id", dev.SourceID, "src-", writes["source"]); ok && dev.SourceName != "Source "+tag {
+			t.Errorf("torn source update: source_id=%q source_name=%q come from different writes", dev.SourceID, dev.SourceName)
+		}
+		fmt.Printf("PASS: device merge invariants held across %d concurrent writes\n", 2*raceWriters*raceRounds)
+	})
+
+	t.Run("entity: concurrent source and local_name updates", func(t *testing.T) {
+		const deviceID = "wg-race-ent-device"
+		const entityID = "wg-race-entity"
+		seedPost(t, *client, base+"/devices", types.Device{ID: deviceID})
+		seedPost(t, *client, base+"/devices/"+deviceID+"/entities", types.Entity{ID: entityID, Domain: "switch", Actions: []string{"turn_on", "turn_off", "seed-action"}, LocalName: "seed local name"})
+
+		entityFile := filepath.Join(dataDir, "devices", deviceID, "entities", entityID+".json")
+		writes, errs := runRace(t, entityFile, func(side, tag string) error {
+			url := base + "/devices/" + deviceID + "/entities"
+			if side == "source" {
+				return racePut(client, url, types.Entity{ID: entityID, Domain: "switch", Actions: []string{"turn_on", "turn_off", "marker-" + tag}})
+			}
+			return racePut(client, url, types.Entity{ID: entityID, LocalName: "Local " + tag})
+		})
+		for _, err := range errs {
+			t.Error(err)
+		}
+
+		ent := readEntityFile(t, dataDir, deviceID, entityID)
+		if ent.Domain != "switch" {
+			t.Errorf("domain: got %q, want %q", ent.Domain, "switch")
+		}
+		if len(ent.Actions) != 3 || ent.Actions[0] != "turn_on" || ent.Actions[1] != "turn_off" {
+			t.Errorf("torn or lost actions: got %v", ent.Actions)
+		} else {
+			checkLastWrite(t, "actions", ent.Actions[2], "marker-", writes["source"])
+		}
+		checkLastWrite(t, "local_name", ent.LocalName, "Local ", writes["local"])
+		fmt.Printf("PASS: entity merge invariants held across %d concurrent writes\n", 2*raceWriters*raceRounds)
+	})
+}
+
+// raceWrite is one acknowledged PUT made by runRace: when it was sent and
+// when the gateway answered it.
+type raceWrite struct {
+	sent, acked time.Time
+}
+
+// runRace runs raceWriters source and raceWriters local goroutines, each
+// calling write raceRounds times with a "writer-round" tag, while a watcher
+// re-reads path and reports any moment at which it is not valid JSON. It
+// returns the acknowledged writes by side and tag, and every failure seen.
+func runRace(t *testing.T, path string, write func(side, tag string) error) (map[string]map[string]raceWrite, []error) {
+	t.Helper()
+
+	var (
+		mu     sync.Mutex
+		errs   []error
+		writes = map[string]map[string]raceWrite{"source": {}, "local": {}}
+	)
+	report := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			data, err := os.ReadFile(path)
+			if err == nil && !json.Valid(data) {
+				report(fmt.Errorf("torn write: %s is not valid JSON mid-race: %q", path, truncate(data, 200)))
+				return
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				report(fmt.Errorf("read %s mid-race: %w", path, err))
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, side := range []string{"source", "local"} {
+		for w := 0; w < raceWriters; w++ {
+			wg.Add(1)
+			go func(side string, w int) {
+				defer wg.Done()
+				for r := 0; r < raceRounds; r++ {
+					tag := fmt.Sprintf("%d-%d", w, r)
+					sent := time.Now()
+					if err := write(side, tag); err != nil {
+						report(fmt.Errorf("%s writer %d round %d: %w", side, w, r, err))
+						return
+					}
+					mu.Lock()
+					writes[side][tag] = raceWrite{sent: sent, acked: time.Now()}
+					mu.Unlock()
+				}
+			}(side, w)
+		}
+	}
+	wg.Wait()
+	cancel()
+	<-watcherDone
+	return writes, errs
+}
+
+// checkLastWrite checks that a field holds the value of a write that could
+// have been applied last: one acknowledged no earlier than the last write of
+// its side was sent. A write acknowledged before another was even sent was
+// applied first, so finding it in the final state is a lost update. It
+// returns the field's writer tag.
+func checkLastWrite(t *testing.T, field, got, prefix string, writes map[string]raceWrite) (string, bool) {
+	t.Helper()
+	var lastTag string
+	var lastSent, lastAcked time.Time
+	for tag, w := range writes {
+		if w.sent.After(lastSent) {
+			lastSent = w.sent
+		}
+		if w.acked.After(lastAcked) {
+			lastTag, lastAcked = tag, w.acked
+		}
+	}
+	tag, ok := writerTag(got, prefix)
+	if !ok {
+		t.Errorf("%s lost: got %q, want a writer's value (last acknowledged %s%s)", field, got, prefix, lastTag)
+		return "", false
+	}
+	w, acked := writes[tag]
+	switch {
+	case !acked:
+		t.Errorf("%s holds %q, a write the gateway never acknowledged", field, got)
+	case w.acked.Before(lastSent):
+		t.Errorf("lost update in %s: holds %q, acknowledged %v before the last write was sent; last acknowledged was %s%s",
+			field, got, lastSent.Sub(w.acked), prefix, lastTag)
+	}
+	return tag, true
+}
+
+// writerTag returns the "writer-round" tag of a value written by runRace,
+// reporting false unless s is prefix followed by a tag a writer produced.
+// Seed values never qualify, so a lost update cannot pass for a write.
+func writerTag(s, prefix string) (string, bool) {
+	tag, ok := strings.CutPrefix(s, prefix)
+	if !ok {
+		return "", false
+	}
+	var w, r int
+	if n, err := fmt.Sscanf(tag, "%d-%d", &w, &r); err != nil || n != 2 || fmt.Sprintf("%d-%d", w, r) != tag {
+		return "", false
+	}
+	return tag, w >= 0 && w < raceWriters && r >= 0 && r < raceRounds
+}
+
+func racePut(client *http.Client, url string, v any) error {
+	body, _ := json.Marshal(v)
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PUT %s: expected 200, got %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+func truncate(data []byte, n int) []byte {
+	if len(data) <= n {
+		return data
+	}
+	return data[:n]
+}
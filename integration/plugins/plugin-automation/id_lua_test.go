@@ -0,0 +1,105 @@
+package pluginautomation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestLuaOnCommandWithIllegalIDs creates entities whose IDs sit at the edges
+// of the ID grammar. Illegal ones — dots would split Lua's
+// plugin.device.entity.Action key — must be rejected or normalized, and
+// legal uppercase and MAC-style ones stored verbatim; whatever is stored
+// must be addressable by an OnCommand subscription.
+func TestLuaOnCommandWithIllegalIDs(t *testing.T) {
+	const pluginID = "plugin-automation"
+	testutil.RequirePlugin(t, pluginID)
+
+	client := http.Client{Timeout: 3 * time.Second}
+	nonce := fmt.Sprintf("%d", time.Now().UnixNano())
+	deviceID := "automation-id-device-" + nonce
+	createDevice(t, client, pluginID, deviceID)
+
+	// A MAC address, as plugin-kasa uses for device IDs.
+	m := nonce[len(nonce)-8:]
+	mac := "AA:BB:" + m[0:2] + ":" + m[2:4] + ":" + m[4:6] + ":" + m[6:8]
+	for name, c := range map[string]struct {
+		id    string
+		legal bool
+	}{
+		"dots":      {"id.switch." + nonce, false},
+		"space":     {"id switch " + nonce, false},
+		"slash":     {"id/switch/" + nonce, false},
+		"uppercase": {"ID-Switch-" + nonce, true},
+		"mac":       {mac, true},
+	} {
+		id := c.id
+		t.Run(name, func(t *testing.T) {
+			localName := "Lua ID " + name + " " + nonce
+			body, _ := json.Marshal(types.Entity{ID: id, Domain: "switch", LocalName: localName})
+			resp, err := client.Post(fmt.Sprintf("%s/devices/%s/entities", testutil.PluginURL(pluginID), deviceID), "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("create entity: %v", err)
+			}
+			resp.Body.Close()
+			if c.legal && resp.StatusCode != http.StatusOK {
+				t.Fatalf("legal ID %q rejected with %d", id, resp.StatusCode)
+			}
+			if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+				t.Logf("%q rejected with %d", id, resp.StatusCode)
+				return
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("create entity %q: unexpected status %d", id, resp.StatusCode)
+			}
+
+			entities, err := testutil.ListEntities(client, pluginID, deviceID)
+			if err != nil {
+				t.Fatalf("list entities: %v", err)
+			}
+			stored := ""
+			for _, e := range entities {
+				if e.LocalName == localName {
+					stored = e.ID
+				}
+			}
+			if c.legal && stored != id {
+				t.Fatalf("legal ID %q stored as %q", id, stored)
+			}
+			if !testutil.ValidID(stored) {
+				t.Fatalf("%q accepted and stored as %q, which does not match %s", id, stored, testutil.IDPattern())
+			}
+
+			scriptPath, statePath := scriptPaths(t, pluginID, deviceID, stored)
+			if err := os.MkdirAll(filepath.Dir(scriptPath), 0o755); err != nil {
+				t.Fatalf("mkdir script dir failed: %v", err)
+			}
+			script := fmt.Sprintf(`
+function OnInit(Ctx)
+  Ctx:OnCommand("%s.%s.%s.PowerOn", "DoPowerOn")
+end
+
+function DoPowerOn(Ctx, Command)
+  local c = Ctx:GetState("press_count")
+  if c == nil then c = 0 end
+  Ctx:SetState("press_count", c + 1)
+end
+`, pluginID, deviceID, stored)
+			if err := os.WriteFile(scriptPath, []byte(script), 0o644); err != nil {
+				t.Fatalf("write script failed: %v", err)
+			}
+			_ = os.Remove(statePath)
+
+			postCommand(t, client, pluginID, deviceID, stored, map[string]any{"type": "PowerOn"})
+			waitForScriptCount(t, statePath, "press_count", 1, 5*time.Second)
+		})
+	}
+}
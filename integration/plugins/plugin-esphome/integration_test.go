@@ -13,7 +13,12 @@ import (
 func TestESPHomeDiscovery(t *testing.T) {
 	const pluginID = "plugin-esphome"
 	testutil.RequirePlugin(t, pluginID)
+	discoverySuite(t, pluginID)
+}
 
+// discoverySuite checks pluginID answers its device list.
+func discoverySuite(t *testing.T, pluginID string) {
+	t.Helper()
 	client := &http.Client{Timeout: 2 * time.Second}
 	// List devices
 	resp, err := client.Get(testutil.APIBaseURL() + "/api/plugins/" + pluginID + "/devices")
@@ -14,7 +14,11 @@ import (
 func TestESPHomeLifecycle(t *testing.T) {
 	const pluginID = "plugin-esphome"
 	testutil.RequirePlugin(t, pluginID)
+	lifecycleSuite(t, pluginID)
+}
 
+// lifecycleSuite walks pluginID's device and entity listings.
+func lifecycleSuite(t *testing.T, pluginID string) {
 	t.Run("Device Listing", func(t *testing.T) {
 		url := fmt.Sprintf("%s/api/plugins/%s/devices", testutil.APIBaseURL(), pluginID)
 		resp, err := (&http.Client{Timeout: 2 * time.Second}).Get(url)
@@ -0,0 +1,34 @@
+package pluginesphome
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestESPHomeProfileMatrix relaunches plugin-esphome with no dashboard, an
+// unreachable one and a malformed URL, plus any profiles under
+// config/plugins/plugin-esphome/profiles, and runs the plugin's bundle,
+// discovery and lifecycle suites against each instance.
+func TestESPHomeProfileMatrix(t *testing.T) {
+	profiles := []testutil.Profile{
+		{Name: "unset", Env: map[string]string{"ESPHOME_DASHBOARD_URL": ""}},
+		{Name: "unreachable", Env: map[string]string{"ESPHOME_DASHBOARD_URL": "http://127.0.0.1:1"}},
+		{Name: "malformed", Env: map[string]string{"ESPHOME_DASHBOARD_URL": "esphome:dashboard"}},
+	}
+	fromFiles, err := testutil.LoadProfiles("plugin-esphome")
+	if err != nil {
+		t.Fatalf("load profiles: %v", err)
+	}
+	profiles = append(profiles, fromFiles...)
+
+	testutil.RunProfiles(t, "plugin-esphome", profiles, func(t *testing.T, p *testutil.PluginProcess, profile testutil.Profile) {
+		t.Run("bundle", func(t *testing.T) { bundleSuite(t, p.ID()) })
+		t.Run("discovery", func(t *testing.T) { discoverySuite(t, p.ID()) })
+		t.Run("lifecycle", func(t *testing.T) { lifecycleSuite(t, p.ID()) })
+		if !testutil.WaitForPlugin(p.ID(), 2*time.Second) {
+			t.Errorf("plugin unhealthy under profile %q", profile.Name)
+		}
+	})
+}
@@ -14,9 +14,10 @@ func TestESPHomeRealDiscovery(t *testing.T) {
 	const pluginID = "plugin-esphome"
 	testutil.RequirePlugin(t, pluginID)
 
+	log := testutil.RedactingLogger(t)
 	url := testutil.PluginEnv(pluginID, "ESPHOME_DASHBOARD_URL", "ESPHOME_URL")
 	if url == "" {
-		t.Skip("no ESPHome dashboard URL configured; skipping real discovery test")
+		log.Skip("no ESPHome dashboard URL configured; skipping real discovery test")
 	}
 
 	client := http.Client{Timeout: 3 * time.Second}
@@ -42,8 +43,8 @@ func TestESPHomeRealDiscovery(t *testing.T) {
 	}
 
 	if max == 0 {
-		t.Fatalf("real ESPHome discovery returned 0 devices within timeout (dashboard configured)")
+		log.Fatalf("real ESPHome discovery returned 0 devices within timeout (dashboard configured)")
 	}
 
-	t.Logf("ESPHome real discovery found %d devices", max)
+	log.Logf("ESPHome real discovery found %d devices", max)
 }
@@ -9,7 +9,12 @@ import (
 func TestBundleExists(t *testing.T) {
 	const pluginID = "plugin-frigate"
 	testutil.RequirePlugin(t, pluginID)
+	bundleSuite(t, pluginID)
+}
 
+// bundleSuite checks pluginID is in the gateway's registry.
+func bundleSuite(t *testing.T, pluginID string) {
+	t.Helper()
 	registry, err := testutil.RegisteredPlugins()
 	if err != nil {
 		t.Fatalf("failed reading plugin registry: %v", err)
@@ -0,0 +1,115 @@
+package pluginfrigate
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+	"github.com/slidebolt/testrunner/local/frigate"
+)
+
+// TestFrigateCameraScenario drives plugin-frigate through a changing camera
+// set on the mock: cameras added and removed mid-test, API errors and slow
+// responses, checking that discovery follows and polling keeps going.
+func TestFrigateCameraScenario(t *testing.T) {
+	testutil.RequirePlugin(t, "plugin-frigate")
+
+	nonce := time.Now().UnixNano()
+	camA := fmt.Sprintf("scenario-a-%d", nonce)
+	camB := fmt.Sprintf("scenario-b-%d", nonce)
+	mock := testutil.StartFrigateMock(t, scenarioCamera(camA))
+	configureFrigate(t, mock.URL())
+
+	waitForDevice(t, "plugin-frigate", "frigate-device-"+camA, 10*time.Second)
+
+	t.Run("polls config, stats and streams", func(t *testing.T) {
+		mock.ResetRequests()
+		time.Sleep(5 * time.Second)
+		for _, path := range []string{"/api/config", "/api/stats", "/api/streams"} {
+			if n := mock.RequestCount(path); n == 0 {
+				t.Errorf("no requests to %s in 5s of polling", path)
+			}
+		}
+	})
+
+	t.Run("camera added mid-test is discovered", func(t *testing.T) {
+		mock.AddCamera(scenarioCamera(camB))
+		waitForDevice(t, "plugin-frigate", "frigate-device-"+camB, 15*time.Second)
+	})
+
+	t.Run("camera removed mid-test is dropped", func(t *testing.T) {
+		mock.RemoveCamera(camB)
+		waitForDeviceGone(t, "frigate-device-"+camB, 15*time.Second)
+		if !pluginListsDevice(t, "frigate-device-"+camA) {
+			t.Errorf("removing %s also dropped %s", camB, camA)
+		}
+	})
+
+	t.Run("survives API errors and keeps polling", func(t *testing.T) {
+		mock.InjectFault("/api/config", frigate.Fault{Status: http.StatusInternalServerError})
+		mock.InjectFault("/api/stats", frigate.Fault{Status: http.StatusBadGateway})
+		mock.ResetRequests()
+		time.Sleep(5 * time.Second)
+		if mock.RequestCount("/api/config") < 2 {
+			t.Errorf("plugin stopped polling /api/config after errors (%d request(s))", mock.RequestCount("/api/config"))
+		}
+		if !pluginListsDevice(t, "frigate-device-"+camA) {
+			t.Errorf("transient API errors dropped camera %s", camA)
+		}
+
+		mock.ClearFaults()
+		mock.AddCamera(scenarioCamera(camB))
+		waitForDevice(t, "plugin-frigate", "frigate-device-"+camB, 15*time.Second)
+	})
+
+	t.Run("survives slow responses", func(t *testing.T) {
+		mock.InjectFault("*", frigate.Fault{Latency: 3 * time.Second, Times: 3})
+		time.Sleep(4 * time.Second)
+		if !pluginListsDevice(t, "frigate-device-"+camA) {
+			t.Errorf("slow API responses dropped camera %s", camA)
+		}
+		if !testutil.WaitForPlugin("plugin-frigate", 5*time.Second) {
+			t.Errorf("plugin-frigate unhealthy after slow API responses")
+		}
+	})
+}
+
+func scenarioCamera(name string) frigate.Camera {
+	return frigate.Camera{
+		Name:       name,
+		Enabled:    true,
+		Detect:     true,
+		Record:     true,
+		CameraFPS:  10,
+		ProcessFPS: 9.5,
+		StreamURL:  "rtsp://mock/" + name,
+	}
+}
+
+func pluginListsDevice(t *testing.T, deviceID string) bool {
+	t.Helper()
+	devices, err := testutil.ListDevices(http.Client{Timeout: 2 * time.Second}, "plugin-frigate")
+	if err != nil {
+		t.Fatalf("list devices: %v", err)
+	}
+	for _, d := range devices {
+		if d.ID == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+func waitForDeviceGone(t *testing.T, deviceID string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !pluginListsDevice(t, deviceID) {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatalf("device %q still listed %v after its camera was removed", deviceID, timeout)
+}
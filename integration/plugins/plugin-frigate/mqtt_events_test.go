@@ -0,0 +1,304 @@
+package pluginfrigate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+	"github.com/slidebolt/testrunner/local/frigate"
+	"github.com/slidebolt/testrunner/local/mqtt"
+)
+
+// TestFrigateMQTTEvents starts an in-process MQTT broker and a
+// harness-owned plugin-frigate pointed at it and at the Frigate mock, replays
+// a scripted Frigate MQTT timeline through the broker, and checks the
+// motion, object count and last-detection state the plugin derives, and that
+// each change is published as an entity event.
+//
+// Beyond the camera's device, the plugin's entity IDs and state keys are not
+// part of any published contract, so the suite finds the entities each topic
+// drives by what changed and matches state by the values the timeline sent,
+// not by key name.
+func TestFrigateMQTTEvents(t *testing.T) {
+	broker, err := mqtt.NewBroker()
+	if err != nil {
+		t.Fatalf("start mqtt broker: %v", err)
+	}
+	t.Cleanup(broker.Close)
+
+	cam := fmt.Sprintf("mqtt-cam-%d", time.Now().UnixNano())
+	mock := testutil.StartFrigateMock(t, scenarioCamera(cam))
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-frigate"),
+		ID:     "plugin-frigate-mqtt",
+		Env: []string{
+			"FRIGATE_URL=" + mock.URL(),
+			"GO2RTC_URL=" + mock.URL(),
+			"MQTT_URL=" + broker.URL(),
+			"FRIGATE_MQTT_URL=" + broker.URL(),
+		},
+	})
+	pluginID := p.ID()
+
+	for _, topic := range []string{"frigate/events", "frigate/" + cam + "/motion", "frigate/" + cam + "/person"} {
+		if !broker.WaitSubscribed(topic, 15*time.Second) {
+			t.Fatalf("%s never subscribed to %s on %s; filters held: %v", pluginID, topic, broker.URL(), broker.Filters())
+		}
+	}
+
+	deviceID := "frigate-device-" + cam
+	waitForDevice(t, pluginID, deviceID, 10*time.Second)
+	waitForEntity(t, pluginID, deviceID, "frigate-entity-"+cam, "", 10*time.Second)
+
+	events := &mqttEventLog{}
+	nc := testutil.ConnectBus(t)
+	testutil.SubscribeEntityEvents(t, nc, pluginID, deviceID, "", events.add)
+
+	start := float64(time.Now().Unix())
+	detection := frigate.Event{ID: "evt-" + cam, Camera: cam, Label: "person", TopScore: 0.82, StartTime: start}
+	updated := detection
+	updated.TopScore = 0.91
+	replay := func(t *testing.T, tl frigate.Timeline) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tl.Replay(ctx, broker); err != nil {
+			t.Fatalf("replay: %v", err)
+		}
+	}
+
+	var motionIDs []string
+	var countID string
+	var motionOn map[string]map[string]any
+
+	t.Run("motion turns on", func(t *testing.T) {
+		before := reportedStates(t, pluginID, deviceID)
+		replay(t, frigate.Timeline{frigate.Motion(0, cam, true)})
+		motionOn = waitForStates(t, pluginID, deviceID, 5*time.Second, "an entity to change on motion ON", func(s map[string]map[string]any) bool {
+			motionIDs = changedEntities(before, s)
+			return len(motionIDs) > 0
+		})
+	})
+
+	t.Run("object count follows detections", func(t *testing.T) {
+		replay(t, frigate.Timeline{frigate.ObjectCount(0, cam, "person", 1)})
+		waitForStates(t, pluginID, deviceID, 5*time.Second, "an entity to report count 1", func(s map[string]map[string]any) bool {
+			for id, state := range s {
+				if holds(state, numberIs(1)) && !holds(motionOn[id], numberIs(1)) {
+					countID = id
+					return true
+				}
+			}
+			return false
+		})
+		replay(t, frigate.Timeline{
+			frigate.EventNew(0, detection),
+			frigate.ObjectCount(200*time.Millisecond, cam, "person", 2),
+			frigate.EventUpdate(300*time.Millisecond, detection, updated),
+		})
+		waitForStates(t, pluginID, deviceID, 5*time.Second, countID+" to report count 2", func(s map[string]map[string]any) bool {
+			return holds(s[countID], numberIs(2))
+		})
+	})
+
+	t.Run("last detection is stamped from the event", func(t *testing.T) {
+		waitForStates(t, pluginID, deviceID, 5*time.Second, "an entity to report the person detection at its start time", func(s map[string]map[string]any) bool {
+			for _, state := range s {
+				if holds(state, func(v any) bool { return v == "person" }) && holds(state, timeIs(start)) {
+					return true
+				}
+			}
+			return false
+		})
+	})
+
+	t.Run("everything clears at the end", func(t *testing.T) {
+		if countID == "" || len(motionIDs) == 0 {
+			t.Skip("earlier steps found no motion or count entity")
+		}
+		replay(t, frigate.Timeline{
+			frigate.ObjectCount(0, cam, "person", 0),
+			frigate.EventEnd(100*time.Millisecond, updated),
+			frigate.Motion(300*time.Millisecond, cam, false),
+		})
+		waitForStates(t, pluginID, deviceID, 5*time.Second, countID+" to report count 0 and motion entities to change back", func(s map[string]map[string]any) bool {
+			if !holds(s[countID], numberIs(0)) {
+				return false
+			}
+			for _, id := range motionIDs {
+				if id != countID && reflect.DeepEqual(s[id], motionOn[id]) {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	t.Run("each change produced an entity event", func(t *testing.T) {
+		if countID == "" || len(motionIDs) == 0 {
+			t.Skip("earlier steps found no motion or count entity")
+		}
+		// Motion on and off; person count 1, 2 and 0.
+		want := map[string]int{countID: 3}
+		for _, id := range motionIDs {
+			if id != countID {
+				want[id] = 2
+			}
+		}
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) && !events.atLeast(want) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		for entityID, n := range want {
+			if got := events.count(entityID); got < n {
+				t.Errorf("%s: %d entity event(s), want at least %d", entityID, got, n)
+			}
+		}
+	})
+}
+
+type mqttEventLog struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (l *mqttEventLog) add(ev testutil.BusEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts == nil {
+		l.counts = map[string]int{}
+	}
+	l.counts[ev.EntityID]++
+}
+
+func (l *mqttEventLog) count(entityID string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts[entityID]
+}
+
+func (l *mqttEventLog) atLeast(want map[string]int) bool {
+	for entityID, n := range want {
+		if l.count(entityID) < n {
+			return false
+		}
+	}
+	return true
+}
+
+// reportedStates returns the decoded reported state of every entity of a
+// device, keyed by entity ID.
+func reportedStates(t *testing.T, pluginID, deviceID string) map[string]map[string]any {
+	t.Helper()
+	states, err := fetchReportedStates(pluginID, deviceID)
+	if err != nil {
+		t.Fatalf("list entities of %s/%s: %v", pluginID, deviceID, err)
+	}
+	return states
+}
+
+func fetchReportedStates(pluginID, deviceID string) (map[string]map[string]any, error) {
+	entities, err := testutil.ListEntities(http.Client{Timeout: 2 * time.Second}, pluginID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	states := map[string]map[string]any{}
+	for _, e := range entities {
+		var state map[string]any
+		_ = json.Unmarshal(e.Data.Reported, &state)
+		states[e.ID] = state
+	}
+	return states, nil
+}
+
+// waitForStates polls a device's entity states until ok accepts them and
+// returns the accepted states.
+func waitForStates(t *testing.T, pluginID, deviceID string, timeout time.Duration, what string, ok func(map[string]map[string]any) bool) map[string]map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	var last map[string]map[string]any
+	for time.Now().Before(deadline) {
+		if states, err := fetchReportedStates(pluginID, deviceID); err == nil {
+			last = states
+			if ok(states) {
+				return states
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatalf("waited %v for %s on %s/%s; last states: %v", timeout, what, pluginID, deviceID, last)
+	return nil
+}
+
+// changedEntities returns the IDs whose state differs between before and
+// after, sorted.
+func changedEntities(before, after map[string]map[string]any) []string {
+	var ids []string
+	for id, state := range after {
+		if !reflect.DeepEqual(before[id], state) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// holds reports whether any value in v, searched through nested objects and
+// arrays, satisfies match.
+func holds(v any, match func(any) bool) bool {
+	switch v := v.(type) {
+	case map[string]any:
+		for _, x := range v {
+			if holds(x, match) {
+				return true
+			}
+		}
+		return false
+	case []any:
+		for _, x := range v {
+			if holds(x, match) {
+				return true
+			}
+		}
+		return false
+	default:
+		return match(v)
+	}
+}
+
+// numberIs matches n as a JSON number or numeric string.
+func numberIs(n float64) func(any) bool {
+	return func(v any) bool {
+		switch v := v.(type) {
+		case float64:
+			return v == n
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			return err == nil && f == n
+		}
+		return false
+	}
+}
+
+// timeIs matches a Unix time in seconds, given as a number or an RFC 3339
+// string.
+func timeIs(unix float64) func(any) bool {
+	return func(v any) bool {
+		switch v := v.(type) {
+		case float64:
+			return v == unix
+		case string:
+			ts, err := time.Parse(time.RFC3339Nano, v)
+			return err == nil && ts.Unix() == int64(unix)
+		}
+		return false
+	}
+}
@@ -0,0 +1,62 @@
+package pluginfrigate
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestFrigateProfileMatrix relaunches plugin-frigate under each config
+// profile — pointed at the mock, at nothing, at a malformed URL, plus any
+// profiles under config/plugins/plugin-frigate/profiles — and runs the
+// plugin's bundle and system device suites against every instance, and the
+// discovery suite where the profile reaches the mock. Elsewhere it checks no
+// camera is discovered.
+func TestFrigateProfileMatrix(t *testing.T) {
+	const cam = "profile-matrix-cam"
+	mock := testutil.StartFrigateMock(t, scenarioCamera(cam))
+
+	profiles := []testutil.Profile{
+		{Name: "mock", Env: map[string]string{"FRIGATE_URL": mock.URL(), "GO2RTC_URL": mock.URL()}},
+		{Name: "unset", Env: map[string]string{"FRIGATE_URL": "", "GO2RTC_URL": ""}},
+		{Name: "malformed", Env: map[string]string{"FRIGATE_URL": "http//frigate:5000", "GO2RTC_URL": "::"}},
+	}
+	fromFiles, err := testutil.LoadProfiles("plugin-frigate")
+	if err != nil {
+		t.Fatalf("load profiles: %v", err)
+	}
+	profiles = append(profiles, fromFiles...)
+
+	testutil.RunProfiles(t, "plugin-frigate", profiles, func(t *testing.T, p *testutil.PluginProcess, profile testutil.Profile) {
+		t.Run("bundle", func(t *testing.T) { bundleSuite(t, p.ID()) })
+		t.Run("system device", func(t *testing.T) { systemDeviceSuite(t, p.ID()) })
+		if profile.Env["FRIGATE_URL"] == mock.URL() {
+			t.Run("discovery", func(t *testing.T) { discoverySuite(t, p.ID(), cam) })
+			return
+		}
+		t.Run("no discovery", func(t *testing.T) {
+			// A camera can appear on any discovery pass, so watch for the
+			// whole window rather than listing once.
+			window := testutil.EnvDuration("TEST_FRIGATE_DISCOVERY_WINDOW", 10*time.Second)
+			client := http.Client{Timeout: 2 * time.Second}
+			deadline := time.Now().Add(window)
+			for {
+				devices, err := testutil.ListDevices(client, p.ID())
+				if err != nil {
+					t.Fatalf("list devices: %v", err)
+				}
+				for _, d := range devices {
+					if d.ID != "frigate-system" {
+						t.Fatalf("device %s discovered with no reachable Frigate", d.ID)
+					}
+				}
+				if time.Now().After(deadline) {
+					return
+				}
+				time.Sleep(500 * time.Millisecond)
+			}
+		})
+	})
+}
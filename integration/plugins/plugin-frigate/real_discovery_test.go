@@ -17,9 +17,10 @@ func TestFrigateRealDiscoveryCount(t *testing.T) {
 	const pluginID = "plugin-frigate"
 	testutil.RequirePlugin(t, pluginID)
 
+	log := testutil.RedactingLogger(t)
 	url := testutil.PluginEnv(pluginID, "FRIGATE_URL", "PLUGIN_FRIGATE_URL", "PLUGIN_FRIGATE_FRIGATE_URL")
 	if strings.TrimSpace(url) == "" {
-		t.Skip("no Frigate URL configured; skipping real discovery count test")
+		log.Skip("no Frigate URL configured; skipping real discovery count test")
 	}
 
 	client := http.Client{Timeout: 2 * time.Second}
@@ -64,9 +65,9 @@ func TestFrigateRealDiscoveryCount(t *testing.T) {
 						entityRows = append(entityRows, fmt.Sprintf("%s: %s", deviceID, strings.Join(parts, ", ")))
 					}
 
-					t.Logf("Frigate discovered %d camera device(s): %s", count, strings.Join(deviceIDs, ", "))
+					log.Logf("Frigate discovered %d camera device(s): %s", count, strings.Join(deviceIDs, ", "))
 					for _, row := range entityRows {
-						t.Logf("entities %s", row)
+						log.Logf("entities %s", row)
 					}
 					return
 				}
@@ -79,7 +80,7 @@ func TestFrigateRealDiscoveryCount(t *testing.T) {
 		time.Sleep(500 * time.Millisecond)
 	}
 
-	t.Fatalf("Frigate real discovery found %d camera devices within timeout", lastCount)
+	log.Fatalf("Frigate real discovery found %d camera devices within timeout", lastCount)
 }
 
 func listEntities(client http.Client, pluginID, deviceID string) ([]types.Entity, error) {
@@ -56,40 +56,44 @@ func TestKasaCommands(t *testing.T) {
 
 	// In a real integration test environment, we might have a mock device
 	// or a specific test device IP configured via environment variables.
+	// Both identify a real device, so everything that prints them goes
+	// through a redacting logger.
+	log := testutil.RedactingLogger(t)
 	testIP := testutil.PluginEnv(pluginID, "KASA_TEST_DEVICE_IP")
 	testMAC := testutil.PluginEnv(pluginID, "KASA_TEST_DEVICE_MAC")
 
 	if testIP == "" || testMAC == "" {
-		t.Skip("KASA_TEST_DEVICE_IP or KASA_TEST_DEVICE_MAC not set; skipping command tests")
+		log.Skip("KASA_TEST_DEVICE_IP or KASA_TEST_DEVICE_MAC not set; skipping command tests")
 	}
 
 	deviceID := testMAC
 	entityID := "power" // Assuming a switch for the test device
 
 	t.Run("Switch Toggle", func(t *testing.T) {
+		log := testutil.RedactingLogger(t)
 		// 1. Ensure device and entity exist (Gateway/Plugin should auto-discover if IP is known)
 		// We'll try to create the device if it doesn't exist to ensure it's in the system.
-		createDevice(t, pluginID, deviceID, testMAC, testIP)
+		createDevice(log, pluginID, deviceID, testMAC, testIP)
 
 		// 2. Send Turn On Command
 		cmdPayload, _ := json.Marshal(entityswitch.Command{Type: entityswitch.ActionTurnOn})
-		status := sendCommand(t, pluginID, deviceID, entityID, cmdPayload)
+		status := sendCommand(log, pluginID, deviceID, entityID, cmdPayload)
 
 		if status.State != types.CommandSucceeded && status.State != types.CommandPending {
-			t.Errorf("expected command success or pending, got %s", status.State)
+			log.Errorf("expected command success or pending, got %s", status.State)
 		}
 
 		// 3. Wait for state to reflect in Gateway
-		waitForState(t, pluginID, deviceID, entityID, true)
+		testutil.WaitForStateEqual(log, pluginID, deviceID, entityID, entityswitch.State{Power: true})
 
 		// 4. Send Turn Off Command
 		cmdPayload, _ = json.Marshal(entityswitch.Command{Type: entityswitch.ActionTurnOff})
-		sendCommand(t, pluginID, deviceID, entityID, cmdPayload)
-		waitForState(t, pluginID, deviceID, entityID, false)
+		sendCommand(log, pluginID, deviceID, entityID, cmdPayload)
+		testutil.WaitForStateEqual(log, pluginID, deviceID, entityID, entityswitch.State{Power: false})
 	})
 }
 
-func createDevice(t *testing.T, pluginID, deviceID, mac, ip string) {
+func createDevice(t testing.TB, pluginID, deviceID, mac, ip string) {
 	dev := types.Device{
 		ID:       deviceID,
 		SourceID: mac,
@@ -103,7 +107,7 @@ func createDevice(t *testing.T, pluginID, deviceID, mac, ip string) {
 	defer resp.Body.Close()
 }
 
-func sendCommand(t *testing.T, pluginID, deviceID, entityID string, payload []byte) types.CommandStatus {
+func sendCommand(t testing.TB, pluginID, deviceID, entityID string, payload []byte) types.CommandStatus {
 	url := fmt.Sprintf("%s/api/plugins/%s/devices/%s/entities/%s/commands", testutil.APIBaseURL(), pluginID, deviceID, entityID)
 	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
 	if err != nil {
@@ -119,29 +123,3 @@ func sendCommand(t *testing.T, pluginID, deviceID, entityID string, payload []by
 	json.NewDecoder(resp.Body).Decode(&status)
 	return status
 }
-
-func waitForState(t *testing.T, pluginID, deviceID, entityID string, expectedPower bool) {
-	deadline := time.Now().Add(10 * time.Second)
-	url := fmt.Sprintf("%s/api/plugins/%s/devices/%s/entities", testutil.APIBaseURL(), pluginID, deviceID)
-
-	for time.Now().Before(deadline) {
-		resp, err := (&http.Client{Timeout: 2 * time.Second}).Get(url)
-		if err == nil {
-			var entities []types.Entity
-			json.NewDecoder(resp.Body).Decode(&entities)
-			resp.Body.Close()
-
-			for _, ent := range entities {
-				if ent.ID == entityID {
-					var state entityswitch.State
-					json.Unmarshal(ent.Data.Reported, &state)
-					if state.Power == expectedPower {
-						return
-					}
-				}
-			}
-		}
-		time.Sleep(500 * time.Millisecond)
-	}
-	t.Errorf("timed out waiting for state power=%v", expectedPower)
-}
\ No newline at end of file
@@ -0,0 +1,158 @@
+package pluginsystem
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestSystemTickTiming collects a window of tick events per system entity
+// from the bus and checks their cadence, and their CreatedAt against the
+// local time each arrived, rather than only that a new event appeared.
+// Tolerances can be overridden with TEST_TICK_* env vars.
+func TestSystemTickTiming(t *testing.T) {
+	testutil.RequirePlugin(t, "plugin-system")
+	client := http.Client{Timeout: 3 * time.Second}
+
+	expected := testutil.EnvDuration("TEST_TICK_INTERVAL", time.Second)
+	window := testutil.EnvDuration("TEST_TICK_WINDOW", 10*time.Second)
+	tol := testutil.TimingTolerance{
+		Mean:   testutil.EnvDuration("TEST_TICK_MEAN_TOLERANCE", 100*time.Millisecond),
+		Jitter: testutil.EnvDuration("TEST_TICK_MAX_JITTER", 150*time.Millisecond),
+		Drift:  testutil.EnvDuration("TEST_TICK_MAX_DRIFT", 500*time.Millisecond),
+	}
+	reportedSkew := testutil.EnvDuration("TEST_TICK_REPORTED_SKEW", 250*time.Millisecond)
+
+	entities := []string{"system-time", "system-date", "system-cpu"}
+
+	nc := testutil.ConnectBus(t)
+	var (
+		ticksMu sync.Mutex
+		ticks   = map[string][]testutil.TickSample{}
+	)
+	testutil.SubscribeEntityEvents(t, nc, "plugin-system", "system-device", "", func(ev testutil.BusEvent) {
+		ticksMu.Lock()
+		ticks[ev.EntityID] = append(ticks[ev.EntityID], testutil.TickSample{Emitted: ev.CreatedAt, Observed: ev.ReceivedAt})
+		ticksMu.Unlock()
+	})
+
+	// Sample reported state throughout the window so each observed
+	// Data.Reported timestamp can be matched to a journal entry afterwards.
+	start := time.Now()
+	var (
+		mu       sync.Mutex
+		reported = map[string]map[time.Time]bool{}
+	)
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+			for entityID, ts := range sampleReportedTimestamps(client) {
+				mu.Lock()
+				if reported[entityID] == nil {
+					reported[entityID] = map[time.Time]bool{}
+				}
+				reported[entityID][ts] = true
+				mu.Unlock()
+			}
+		}
+	}()
+	time.Sleep(window)
+	close(stop)
+	<-sampled
+	end := time.Now()
+
+	for _, entityID := range entities {
+		t.Run(entityID, func(t *testing.T) {
+			events, err := testutil.JournalEvents(client, testutil.JournalQuery{
+				PluginID: "plugin-system",
+				DeviceID: "system-device",
+				EntityID: entityID,
+				Since:    start,
+				Until:    end,
+			})
+			if err != nil {
+				t.Fatalf("journal query: %v", err)
+			}
+			created := make([]time.Time, 0, len(events))
+			for _, e := range events {
+				created = append(created, e.CreatedAt)
+			}
+			var samples []testutil.TickSample
+			ticksMu.Lock()
+			for _, tick := range ticks[entityID] {
+				if !tick.Observed.Before(start) && !tick.Observed.After(end) {
+					samples = append(samples, tick)
+				}
+			}
+			ticksMu.Unlock()
+			stats := testutil.AnalyzeTicks(samples, expected)
+			t.Logf("%s over %s: %s", entityID, window, stats)
+			if err := stats.Check(tol); err != nil {
+				t.Errorf("%s tick timing: %v", entityID, err)
+			}
+
+			mu.Lock()
+			seen := reported[entityID]
+			mu.Unlock()
+			if len(seen) == 0 {
+				t.Fatalf("no Data.Reported timestamps sampled for %s", entityID)
+			}
+			for ts := range seen {
+				if ts.Before(start) || ts.After(end) {
+					continue
+				}
+				if !hasCreatedNear(created, ts, reportedSkew) {
+					t.Errorf("%s reported ts %s has no journal event within %s", entityID, ts.Format(time.RFC3339Nano), reportedSkew)
+				}
+			}
+		})
+	}
+}
+
+// sampleReportedTimestamps reads the "ts" field of every system entity's
+// reported state.
+func sampleReportedTimestamps(client http.Client) map[string]time.Time {
+	entities, err := testutil.ListEntities(client, "plugin-system", "system-device")
+	if err != nil {
+		return nil
+	}
+	out := map[string]time.Time{}
+	for _, e := range entities {
+		var state struct {
+			TS string `json:"ts"`
+		}
+		if json.Unmarshal(e.Data.Reported, &state) != nil || state.TS == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, state.TS)
+		if err != nil {
+			continue
+		}
+		out[e.ID] = ts
+	}
+	return out
+}
+
+func hasCreatedNear(created []time.Time, ts time.Time, skew time.Duration) bool {
+	for _, c := range created {
+		d := c.Sub(ts)
+		if d < 0 {
+			d = -d
+		}
+		if d <= skew {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package plugintestclean
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestLabelManagement covers changing labels after creation: a PUT with a
+// labels map replaces the whole set, so removing one label means sending
+// the rest; "labels": {} clears them; and an update that omits labels — a
+// source push through the walled-garden merge — leaves them alone. Each
+// step is checked in the persisted JSON and in label= search.
+func TestLabelManagement(t *testing.T) {
+	testutil.RequirePlugin(t, pluginID)
+	dataDir := testutil.PluginDataDir(pluginID)
+	if dataDir == "" {
+		t.Fatal("could not locate plugin data directory")
+	}
+
+	client := http.Client{Timeout: 2 * time.Second}
+	nonce := fmt.Sprintf("%d", time.Now().UnixNano())
+	deviceID := "label-mgmt-dev-" + nonce
+	entityID := "label-mgmt-entity-" + nonce
+	devURL := fmt.Sprintf("%s/api/plugins/%s/devices", testutil.APIBaseURL(), pluginID)
+	deviceFile := filepath.Join(dataDir, "devices", deviceID+".json")
+	entityFile := filepath.Join(dataDir, "devices", deviceID, "entities", entityID+".json")
+
+	initial := map[string]string{"room": "office-" + nonce, "floor": "first-" + nonce}
+	postJSON(t, client, devURL, types.Device{ID: deviceID, SourceID: "src-" + nonce, SourceName: "Label Source", LocalName: "Label Mgmt", Labels: initial})
+	postJSON(t, client, devURL+"/"+deviceID+"/entities", types.Entity{ID: entityID, DeviceID: deviceID, Domain: "switch", Labels: map[string]string{"group": "g-" + nonce}})
+	assertDeviceLabels(t, client, deviceFile, deviceID, initial, nil)
+
+	t.Run("Device: PUT replaces labels", func(t *testing.T) {
+		next := map[string]string{"room": "kitchen-" + nonce, "floor": "first-" + nonce}
+		if err := testutil.PutDeviceLabels(client, pluginID, deviceID, next); err != nil {
+			t.Fatalf("put labels: %v", err)
+		}
+		assertDeviceLabels(t, client, deviceFile, deviceID, next, map[string]string{"room": "office-" + nonce})
+		fmt.Println("PASS: device labels replaced by PUT")
+	})
+
+	t.Run("Device: remove a single label", func(t *testing.T) {
+		next := map[string]string{"room": "kitchen-" + nonce}
+		if err := testutil.PutDeviceLabels(client, pluginID, deviceID, next); err != nil {
+			t.Fatalf("put labels: %v", err)
+		}
+		assertDeviceLabels(t, client, deviceFile, deviceID, next, map[string]string{"floor": "first-" + nonce})
+		fmt.Println("PASS: single device label removed")
+	})
+
+	t.Run("Device: source update without labels keeps user labels", func(t *testing.T) {
+		body, _ := json.Marshal(types.Device{ID: deviceID, SourceID: "src-" + nonce, SourceName: "Label Source Updated"})
+		putRaw(t, client, devURL, body)
+		assertDeviceLabels(t, client, deviceFile, deviceID, map[string]string{"room": "kitchen-" + nonce}, nil)
+		fmt.Println("PASS: device labels survived source update")
+	})
+
+	t.Run("Device: null labels treated as omitted", func(t *testing.T) {
+		if err := testutil.PutDeviceLabels(client, pluginID, deviceID, nil); err != nil {
+			t.Fatalf("put labels: %v", err)
+		}
+		assertDeviceLabels(t, client, deviceFile, deviceID, map[string]string{"room": "kitchen-" + nonce}, nil)
+		fmt.Println("PASS: null device labels left labels unchanged")
+	})
+
+	t.Run("Device: empty labels clear all", func(t *testing.T) {
+		if err := testutil.PutDeviceLabels(client, pluginID, deviceID, map[string]string{}); err != nil {
+			t.Fatalf("put labels: %v", err)
+		}
+		assertDeviceLabels(t, client, deviceFile, deviceID, map[string]string{}, map[string]string{"room": "kitchen-" + nonce})
+		fmt.Println("PASS: device labels cleared")
+	})
+
+	t.Run("Entity: replace, remove and clear", func(t *testing.T) {
+		steps := []struct {
+			labels map[string]string
+			gone   map[string]string
+		}{
+			{map[string]string{"group": "h-" + nonce, "zone": "z-" + nonce}, map[string]string{"group": "g-" + nonce}},
+			{map[string]string{"zone": "z-" + nonce}, map[string]string{"group": "h-" + nonce}},
+			{map[string]string{}, map[string]string{"zone": "z-" + nonce}},
+		}
+		for _, step := range steps {
+			if err := testutil.PutEntityLabels(client, pluginID, deviceID, entityID, step.labels); err != nil {
+				t.Fatalf("put labels: %v", err)
+			}
+			var ent types.Entity
+			readJSONFile(t, entityFile, &ent)
+			if !maps.Equal(nonNil(ent.Labels), step.labels) {
+				t.Errorf("persisted entity labels %v, want %v", ent.Labels, step.labels)
+			}
+			if len(step.labels) > 0 && !containsEntity(searchEntities(t, client, testutil.LabelQuery(step.labels)), entityID) {
+				t.Errorf("entity not found by %s", testutil.LabelQuery(step.labels))
+			}
+			for k, v := range step.gone {
+				if containsEntity(searchEntities(t, client, testutil.LabelQuery(map[string]string{k: v})), entityID) {
+					t.Errorf("entity still found by removed label %s:%s", k, v)
+				}
+			}
+		}
+		fmt.Println("PASS: entity labels replaced, removed and cleared")
+	})
+
+	t.Run("Entity: update without labels keeps labels", func(t *testing.T) {
+		labels := map[string]string{"group": "k-" + nonce}
+		if err := testutil.PutEntityLabels(client, pluginID, deviceID, entityID, labels); err != nil {
+			t.Fatalf("put labels: %v", err)
+		}
+		body, _ := json.Marshal(types.Entity{ID: entityID, DeviceID: deviceID, Domain: "switch", LocalName: "Renamed"})
+		putRaw(t, client, devURL+"/"+deviceID+"/entities", body)
+		var ent types.Entity
+		readJSONFile(t, entityFile, &ent)
+		if !maps.Equal(ent.Labels, labels) {
+			t.Errorf("persisted entity labels %v after update without labels, want %v", ent.Labels, labels)
+		}
+		fmt.Println("PASS: entity labels survived update without labels")
+	})
+}
+
+// assertDeviceLabels checks the persisted labels equal want, that search by
+// all of want finds the device, and that search by each of gone does not.
+func assertDeviceLabels(t *testing.T, client http.Client, path, deviceID string, want, gone map[string]string) {
+	t.Helper()
+	var dev types.Device
+	readJSONFile(t, path, &dev)
+	if !maps.Equal(nonNil(dev.Labels), want) {
+		t.Errorf("persisted device labels %v, want %v", dev.Labels, want)
+	}
+	if len(want) > 0 && !containsDevice(searchDevices(t, client, testutil.LabelQuery(want)), deviceID) {
+		t.Errorf("device not found by %s", testutil.LabelQuery(want))
+	}
+	for k, v := range gone {
+		if containsDevice(searchDevices(t, client, testutil.LabelQuery(map[string]string{k: v})), deviceID) {
+			t.Errorf("device still found by removed label %s:%s", k, v)
+		}
+	}
+}
+
+func nonNil(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+func readJSONFile(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%s invalid JSON: %v", path, err)
+	}
+}
+
+func postJSON(t *testing.T, client http.Client, url string, v any) {
+	t.Helper()
+	body, _ := json.Marshal(v)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: unexpected status %d", url, resp.StatusCode)
+	}
+}
+
+func putRaw(t *testing.T, client http.Client, url string, body []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("PUT %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT %s: unexpected status %d", url, resp.StatusCode)
+	}
+}
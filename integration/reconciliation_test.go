@@ -0,0 +1,215 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	entityswitch "github.com/slidebolt/sdk-entities/switch"
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestDesiredReportedReconciliation checks both halves of entity state on a
+// well-behaved plugin: a command's desired state is recorded as soon as it
+// is acknowledged, reported state converges once the plugin applies it, and
+// both are persisted to the entity file.
+func TestDesiredReportedReconciliation(t *testing.T) {
+	const pluginID = "plugin-test-clean"
+	testutil.RequirePlugin(t, pluginID)
+	client := http.Client{Timeout: 3 * time.Second}
+	dataDir := testutil.PluginDataDir(pluginID)
+	deviceID, entityID := reconcileSetup(t, client, pluginID)
+
+	for _, power := range []bool{true, false, true} {
+		action := entityswitch.ActionTurnOff
+		if power {
+			action = entityswitch.ActionTurnOn
+		}
+		t.Run(action, func(t *testing.T) {
+			if _, err := testutil.PostCommand(client, pluginID, deviceID, entityID, entityswitch.Command{Type: action}); err != nil {
+				t.Fatalf("send %s: %v", action, err)
+			}
+			// Desired is recorded on acknowledgement, before the plugin acts,
+			// so the first read after the ack must already show it: no
+			// polling, which would also pass if the plugin wrote it later.
+			if d, r := switchStates(t, reconcileEntity(t, client, pluginID, deviceID, entityID)); d == nil || d.Power != power {
+				t.Fatalf("desired=%v reported=%v right after %s was acknowledged, want desired power=%v", d, r, action, power)
+			}
+			waitForSwitch(t, client, pluginID, deviceID, entityID, 5*time.Second, func(d, r *entityswitch.State) bool {
+				return r != nil && r.Power == power
+			})
+			if dataDir != "" {
+				d, r := switchStates(t, readEntityFile(t, dataDir, deviceID, entityID))
+				if d == nil || d.Power != power || r == nil || r.Power != power {
+					t.Errorf("persisted desired=%v reported=%v, want power=%v for both", d, r, power)
+				}
+			}
+		})
+	}
+	fmt.Println("PASS: Desired state recorded on ack and reported state converges")
+}
+
+// TestDesiredReportedMismatchSurfaced sends commands to plugin-test-flaky
+// until one fails to apply, then checks the failure is visible. A command
+// that is acknowledged and then fails must end failed with an error, with
+// desired holding the requested state while reported keeps the last applied
+// one. A command rejected at submission was never acknowledged, so desired
+// must not move either. Both are checked in the API and on disk, and a later
+// successful command must reconcile the two.
+func TestDesiredReportedMismatchSurfaced(t *testing.T) {
+	const pluginID = "plugin-test-flaky"
+	testutil.RequirePlugin(t, pluginID)
+	client := http.Client{Timeout: 3 * time.Second}
+	dataDir := testutil.PluginDataDir(pluginID)
+	deviceID, entityID := reconcileSetup(t, client, pluginID)
+
+	power := false
+	var failed bool
+	for i := 0; i < 30 && !failed; i++ {
+		want := !power
+		action := entityswitch.ActionTurnOff
+		if want {
+			action = entityswitch.ActionTurnOn
+		}
+		prev, _ := switchStates(t, reconcileEntity(t, client, pluginID, deviceID, entityID))
+		status, err := testutil.PostCommand(client, pluginID, deviceID, entityID, entityswitch.Command{Type: action})
+		if err != nil {
+			failed = true
+			t.Logf("%s rejected at submission: %v", action, err)
+			assertFailedApply(t, client, dataDir, pluginID, deviceID, entityID, prev, power)
+			break
+		}
+		final := testutil.WaitForCommand(t, client, status, 5*time.Second)
+		if final.State == types.CommandSucceeded {
+			waitForSwitch(t, client, pluginID, deviceID, entityID, 5*time.Second, func(_, r *entityswitch.State) bool {
+				return r != nil && r.Power == want
+			})
+			power = want
+			continue
+		}
+		failed = true
+		if final.Error == "" {
+			t.Errorf("failed command %s has no error", final.CommandID)
+		}
+		assertFailedApply(t, client, dataDir, pluginID, deviceID, entityID, &entityswitch.State{Power: want}, power)
+	}
+	if !failed {
+		t.Skip("plugin-test-flaky applied 30 commands without a failure")
+	}
+
+	// Retry until the mismatch is reconciled. Every further failure, at
+	// submission or after, must leave reported where it was.
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		d, r := switchStates(t, reconcileEntity(t, client, pluginID, deviceID, entityID))
+		action := entityswitch.ActionTurnOff
+		if d != nil && d.Power {
+			action = entityswitch.ActionTurnOn
+		}
+		status, err := testutil.PostCommand(client, pluginID, deviceID, entityID, entityswitch.Command{Type: action})
+		if err == nil && testutil.WaitForCommand(t, client, status, 5*time.Second).State == types.CommandSucceeded {
+			waitForSwitch(t, client, pluginID, deviceID, entityID, 5*time.Second, func(d, r *entityswitch.State) bool {
+				return d != nil && r != nil && d.Power == r.Power
+			})
+			fmt.Println("PASS: Failed command surfaced as desired/reported mismatch and reconciled on retry")
+			return
+		}
+		if _, r2 := switchStates(t, reconcileEntity(t, client, pluginID, deviceID, entityID)); r != nil && (r2 == nil || r2.Power != r.Power) {
+			t.Fatalf("reported power changed from %v to %v by a command that failed", r.Power, r2)
+		}
+	}
+	t.Fatalf("desired and reported never reconciled after the failed command")
+}
+
+// assertFailedApply checks that after a failed command desired matches
+// wantDesired (nil for never set) and reported still holds the last applied
+// power, in the API and, when dataDir is known, on disk.
+func assertFailedApply(t *testing.T, client http.Client, dataDir, pluginID, deviceID, entityID string, wantDesired *entityswitch.State, applied bool) {
+	t.Helper()
+	_, r := waitForSwitch(t, client, pluginID, deviceID, entityID, 2*time.Second, func(d, _ *entityswitch.State) bool {
+		return samePower(d, wantDesired)
+	})
+	if r != nil && r.Power != applied {
+		t.Errorf("reported power %v after a failed command, want last applied %v", r.Power, applied)
+	}
+	if dataDir != "" {
+		pd, pr := switchStates(t, readEntityFile(t, dataDir, deviceID, entityID))
+		if !samePower(pd, wantDesired) || (pr != nil && pr.Power != applied) {
+			t.Errorf("persisted desired=%v reported=%v, want desired %v and reported %v", pd, pr, wantDesired, applied)
+		}
+	}
+}
+
+func samePower(a, b *entityswitch.State) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Power == b.Power
+}
+
+func reconcileSetup(t *testing.T, client http.Client, pluginID string) (string, string) {
+	t.Helper()
+	nonce := time.Now().UnixNano()
+	deviceID := fmt.Sprintf("reconcile-device-%d", nonce)
+	entityID := fmt.Sprintf("reconcile-switch-%d", nonce)
+	base := testutil.PluginURL(pluginID)
+	seedPost(t, client, base+"/devices", types.Device{ID: deviceID, LocalName: "Reconcile"})
+	seedPost(t, client, base+"/devices/"+deviceID+"/entities", types.Entity{
+		ID:      entityID,
+		Domain:  "switch",
+		Actions: []string{entityswitch.ActionTurnOn, entityswitch.ActionTurnOff},
+	})
+	return deviceID, entityID
+}
+
+func reconcileEntity(t *testing.T, client http.Client, pluginID, deviceID, entityID string) types.Entity {
+	t.Helper()
+	entities, err := testutil.ListEntities(client, pluginID, deviceID)
+	if err != nil {
+		t.Fatalf("list entities: %v", err)
+	}
+	for _, e := range entities {
+		if e.ID == entityID {
+			return e
+		}
+	}
+	t.Fatalf("entity %s/%s not listed", deviceID, entityID)
+	return types.Entity{}
+}
+
+// switchStates decodes an entity's desired and reported switch state; either
+// is nil when unset.
+func switchStates(t *testing.T, e types.Entity) (desired, reported *entityswitch.State) {
+	t.Helper()
+	decode := func(raw json.RawMessage) *entityswitch.State {
+		if len(raw) == 0 || string(raw) == "null" {
+			return nil
+		}
+		var s entityswitch.State
+		if err := json.Unmarshal(raw, &s); err != nil {
+			t.Fatalf("decode switch state %s: %v", raw, err)
+		}
+		return &s
+	}
+	return decode(e.Data.Desired), decode(e.Data.Reported)
+}
+
+// waitForSwitch polls the entity until ok holds and returns the last states
+// seen. It fails the test on timeout.
+func waitForSwitch(t *testing.T, client http.Client, pluginID, deviceID, entityID string, timeout time.Duration, ok func(desired, reported *entityswitch.State) bool) (*entityswitch.State, *entityswitch.State) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		d, r := switchStates(t, reconcileEntity(t, client, pluginID, deviceID, entityID))
+		if ok(d, r) {
+			return d, r
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s/%s state never matched within %v: desired=%v reported=%v", deviceID, entityID, timeout, d, r)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,291 @@
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// TestDistributedSearchConsistency checks, plugin by plugin, that the
+// gateway's fanned-out search returns exactly what each registered plugin
+// lists through its own routes: nothing missing, nothing duplicated, and
+// nothing the plugin never listed. It then holds the unfiltered search to the
+// same rules against the union of all listings, and checks it returns the
+// same set as the per-plugin searches combined.
+func TestDistributedSearchConsistency(t *testing.T) {
+	client := http.Client{Timeout: 10 * time.Second}
+
+	registry, err := testutil.RegisteredPlugins()
+	if err != nil {
+		t.Fatalf("failed to fetch plugin registry: %v", err)
+	}
+
+	// Other packages may create devices while this runs, so only devices
+	// present in listings taken both before and after the search are held
+	// to the "must be found" rule, and only devices in neither listing count
+	// as phantoms.
+	before := listAll(t, client, registry)
+	searched := map[string]listing{}
+	for pluginID := range before {
+		devices, err := testutil.SearchDevices(client, "q=*&plugin_id="+pluginID)
+		if err != nil {
+			t.Errorf("device search for %s: %v", pluginID, err)
+			continue
+		}
+		entities, err := testutil.SearchEntities(client, "q=*&plugin_id="+pluginID)
+		if err != nil {
+			t.Errorf("entity search for %s: %v", pluginID, err)
+			continue
+		}
+		got := listing{devices: map[string]int{}, entities: map[string]int{}}
+		for _, d := range devices {
+			got.devices[d.ID]++
+		}
+		for _, e := range entities {
+			got.entities[e.DeviceID+"/"+e.ID]++
+		}
+		searched[pluginID] = got
+	}
+	devAll, err := testutil.SearchDevices(client, "q=*")
+	if err != nil {
+		t.Fatalf("device search: %v", err)
+	}
+	entAll, err := testutil.SearchEntities(client, "q=*")
+	if err != nil {
+		t.Fatalf("entity search: %v", err)
+	}
+	after := listAll(t, client, registry)
+
+	pluginIDs := make([]string, 0, len(searched))
+	for pluginID := range searched {
+		pluginIDs = append(pluginIDs, pluginID)
+	}
+	sort.Strings(pluginIDs)
+	for _, pluginID := range pluginIDs {
+		t.Run(pluginID, func(t *testing.T) {
+			b, a, got := before[pluginID], after[pluginID], searched[pluginID]
+			compareListing(t, "device", b.devices, a.devices, got.devices)
+			compareListing(t, "entity", b.entities, a.entities, got.entities)
+			fmt.Printf("PASS: %s search matched its listing (%d devices, %d entities)\n", pluginID, len(got.devices), len(got.entities))
+		})
+	}
+
+	t.Run("unfiltered", func(t *testing.T) {
+		all := listing{devices: map[string]int{}, entities: map[string]int{}}
+		for _, d := range devAll {
+			all.devices[d.ID]++
+		}
+		for _, e := range entAll {
+			all.entities[e.DeviceID+"/"+e.ID]++
+		}
+		b, a, per := union(before), union(after), union(searched)
+		compareListing(t, "device", b.devices, a.devices, all.devices)
+		compareListing(t, "entity", b.entities, a.entities, all.entities)
+		compareAggregate(t, "device", b.devices, a.devices, per.devices, all.devices)
+		compareAggregate(t, "entity", b.entities, a.entities, per.entities, all.entities)
+		fmt.Printf("PASS: unfiltered search matched the union of plugins (%d devices, %d entities)\n", len(devAll), len(entAll))
+	})
+	fmt.Printf("PASS: search matched per-plugin listings across %d plugin(s)\n", len(pluginIDs))
+}
+
+// compareListing reports ids listed before and after but missing from
+// search, searched more often than listed, or searched but never listed.
+func compareListing(t *testing.T, kind string, before, after, searched map[string]int) {
+	t.Helper()
+	var missing, duplicated, phantom []string
+	for id, n := range before {
+		if m := after[id]; m > 0 && searched[id] < min(n, m) {
+			missing = append(missing, id)
+		}
+	}
+	for id, got := range searched {
+		listed := max(before[id], after[id])
+		switch {
+		case listed == 0:
+			phantom = append(phantom, id)
+		case got > listed:
+			duplicated = append(duplicated, fmt.Sprintf("%s (listed %d, searched %d)", id, listed, got))
+		}
+	}
+	for _, ids := range [][]string{missing, duplicated, phantom} {
+		sort.Strings(ids)
+	}
+	if len(missing) > 0 {
+		t.Errorf("%d %s(s) listed but missing from search: %v", len(missing), kind, missing)
+	}
+	if len(duplicated) > 0 {
+		t.Errorf("%d %s(s) duplicated in search: %v", len(duplicated), kind, duplicated)
+	}
+	if len(phantom) > 0 {
+		t.Errorf("%d %s(s) in search but never listed: %v", len(phantom), kind, phantom)
+	}
+}
+
+// compareAggregate checks that, for every id listed unchanged before and
+// after, the unfiltered search returned it exactly as often as the
+// per-plugin searches did in total.
+func compareAggregate(t *testing.T, kind string, before, after, perPlugin, aggregate map[string]int) {
+	t.Helper()
+	var diff []string
+	for id, n := range before {
+		if after[id] != n {
+			continue
+		}
+		if perPlugin[id] != aggregate[id] {
+			diff = append(diff, fmt.Sprintf("%s (per-plugin %d, unfiltered %d)", id, perPlugin[id], aggregate[id]))
+		}
+	}
+	sort.Strings(diff)
+	if len(diff) > 0 {
+		t.Errorf("%d %s(s) differ between unfiltered and per-plugin search: %v", len(diff), kind, diff)
+	}
+}
+
+// union sums per-plugin listings into one.
+func union(byPlugin map[string]listing) listing {
+	out := listing{devices: map[string]int{}, entities: map[string]int{}}
+	for _, l := range byPlugin {
+		for id, n := range l.devices {
+			out.devices[id] += n
+		}
+		for id, n := range l.entities {
+			out.entities[id] += n
+		}
+	}
+	return out
+}
+
+// TestDistributedSearchWithSlowPlugin checks that a slow plugin delays the
+// aggregated search, within a bound, without dropping its own results or
+// hiding the other plugins'.
+func TestDistributedSearchWithSlowPlugin(t *testing.T) {
+	testutil.RequirePlugins(t, "plugin-test-clean", "plugin-test-slow")
+	client := http.Client{Timeout: 15 * time.Second}
+
+	nonce := time.Now().UnixNano()
+	cleanID := fmt.Sprintf("dsearch-clean-%d", nonce)
+	slowID := fmt.Sprintf("dsearch-slow-%d", nonce)
+	seedPost(t, client, testutil.PluginURL("plugin-test-clean")+"/devices", types.Device{ID: cleanID})
+	seedPost(t, client, testutil.PluginURL("plugin-test-slow")+"/devices", types.Device{ID: slowID})
+
+	const bound = 10 * time.Second
+	start := time.Now()
+	results, err := testutil.SearchDevices(client, "q=*")
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("search with slow plugin failed after %s: %v", elapsed, err)
+	}
+	if elapsed > bound {
+		t.Errorf("search took %s with a slow plugin, want under %s", elapsed, bound)
+	}
+	ids := map[string]bool{}
+	for _, d := range results {
+		ids[d.ID] = true
+	}
+	if !ids[cleanID] {
+		t.Errorf("fast plugin's device %q missing when a slow plugin is registered", cleanID)
+	}
+	if !ids[slowID] {
+		t.Errorf("slow plugin's device %q missing: search returned after %s without waiting for it", slowID, elapsed)
+	}
+	fmt.Printf("PASS: search with slow plugin returned %d result(s) in %s\n", len(results), elapsed)
+}
+
+// TestDistributedSearchWithUnresponsivePlugin freezes a harness-owned plugin
+// so it stays registered but never answers, and checks that search still
+// returns within a bound with the remaining plugins' results, every time:
+// repeated searches must not pile up behind the frozen plugin.
+func TestDistributedSearchWithUnresponsivePlugin(t *testing.T) {
+	testutil.RequirePlugin(t, "plugin-test-clean")
+	p := testutil.StartPlugin(t, testutil.PluginOptions{
+		Binary: testutil.PluginBinary("plugin-test-clean"),
+		ID:     "plugin-test-clean-unresponsive",
+	})
+	client := http.Client{Timeout: 20 * time.Second}
+
+	nonce := time.Now().UnixNano()
+	liveID := fmt.Sprintf("dsearch-live-%d", nonce)
+	frozenID := fmt.Sprintf("dsearch-frozen-%d", nonce)
+	seedPost(t, client, testutil.PluginURL("plugin-test-clean")+"/devices", types.Device{ID: liveID})
+	seedPost(t, client, testutil.PluginURL(p.ID())+"/devices", types.Device{ID: frozenID})
+
+	p.Pause()
+	defer p.Resume()
+
+	const bound = 15 * time.Second
+	var results []types.Device
+	for i := range 2 {
+		start := time.Now()
+		var err error
+		results, err = testutil.SearchDevices(client, "q=*")
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("search %d with unresponsive plugin failed after %s: %v", i+1, elapsed, err)
+		}
+		if elapsed > bound {
+			t.Errorf("search %d took %s with an unresponsive plugin, want under %s", i+1, elapsed, bound)
+		}
+		found := map[string]bool{}
+		for _, d := range results {
+			found[d.ID] = true
+		}
+		if !found[liveID] {
+			t.Errorf("search %d: responsive plugin's device %q missing when another plugin hangs", i+1, liveID)
+		}
+		if n := countDevice(results, frozenID); n > 1 {
+			t.Errorf("search %d: frozen plugin's device %q returned %d times", i+1, frozenID, n)
+		}
+	}
+	fmt.Printf("PASS: search with unresponsive plugin returned %d result(s) within %s\n", len(results), bound)
+}
+
+func countDevice(devices []types.Device, id string) int {
+	n := 0
+	for _, d := range devices {
+		if d.ID == id {
+			n++
+		}
+	}
+	return n
+}
+
+type listing struct {
+	devices  map[string]int // device ID -> times listed
+	entities map[string]int // deviceID/entityID -> times listed
+}
+
+// listAll lists every device and entity of each registered plugin, keyed by
+// plugin ID.
+func listAll(t *testing.T, client http.Client, registry map[string]types.Registration) map[string]listing {
+	t.Helper()
+	all := map[string]listing{}
+	for pluginID := range registry {
+		if pluginID == "gateway" {
+			continue
+		}
+		out := listing{devices: map[string]int{}, entities: map[string]int{}}
+		devices, err := testutil.ListDevices(client, pluginID)
+		if err != nil {
+			t.Errorf("list devices for %s: %v", pluginID, err)
+			continue
+		}
+		for _, d := range devices {
+			out.devices[d.ID]++
+			entities, err := testutil.ListEntities(client, pluginID, d.ID)
+			if err != nil {
+				t.Errorf("list entities for %s/%s: %v", pluginID, d.ID, err)
+				continue
+			}
+			for _, e := range entities {
+				out.entities[d.ID+"/"+e.ID]++
+			}
+		}
+		all[pluginID] = out
+	}
+	return all
+}
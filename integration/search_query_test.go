@@ -0,0 +1,236 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+	"github.com/slidebolt/testrunner/integration/testutil"
+)
+
+// searchCorpus is a known set of devices and entities seeded across plugins.
+// Every ID carries the corpus prefix so results can be narrowed to it
+// regardless of what else the runtime holds.
+type searchCorpus struct {
+	prefix   string
+	devices  map[string][]types.Device // keyed by plugin ID
+	entities map[string]map[string][]types.Entity
+}
+
+func seedSearchCorpus(t *testing.T, client http.Client) searchCorpus {
+	t.Helper()
+	prefix := fmt.Sprintf("sq-%d-", time.Now().UnixNano())
+	c := searchCorpus{
+		prefix: prefix,
+		devices: map[string][]types.Device{
+			"plugin-test-clean": {{ID: prefix + "kitchen", LocalName: "Kitchen Hub", Labels: map[string]string{"room": "kitchen", "floor": "ground"}}},
+			"plugin-test-slow":  {{ID: prefix + "garage", LocalName: "Garage Hub", Labels: map[string]string{"room": "garage", "floor": "ground"}}},
+		},
+		entities: map[string]map[string][]types.Entity{
+			"plugin-test-clean": {
+				prefix + "kitchen": {
+					{ID: prefix + "light", Domain: "switch", Labels: map[string]string{"group": "lights"}},
+					{ID: prefix + "sensor", Domain: "sensor", Labels: map[string]string{"group": "sensors"}},
+				},
+			},
+			"plugin-test-slow": {
+				prefix + "garage": {
+					{ID: prefix + "door", Domain: "switch", Labels: map[string]string{"group": "doors"}},
+				},
+			},
+		},
+	}
+	for pluginID, devices := range c.devices {
+		for _, dev := range devices {
+			seedPost(t, client, testutil.APIBaseURL()+"/api/plugins/"+pluginID+"/devices", dev)
+		}
+	}
+	for pluginID, byDevice := range c.entities {
+		for deviceID, entities := range byDevice {
+			for _, ent := range entities {
+				ent.DeviceID = deviceID
+				seedPost(t, client, testutil.APIBaseURL()+"/api/plugins/"+pluginID+"/devices/"+deviceID+"/entities", ent)
+			}
+		}
+	}
+	return c
+}
+
+func seedPost(t testing.TB, client http.Client, url string, v any) {
+	t.Helper()
+	body, _ := json.Marshal(v)
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: expected 200, got %d", url, resp.StatusCode)
+	}
+}
+
+func TestSearchQueryLanguage(t *testing.T) {
+	testutil.RequirePlugins(t, "plugin-test-clean", "plugin-test-slow")
+
+	client := http.Client{Timeout: 5 * time.Second}
+	c := seedSearchCorpus(t, client)
+	p := c.prefix
+	q := url.QueryEscape
+
+	deviceCases := []struct {
+		name  string
+		query string
+		want  []string // corpus-local IDs, without prefix
+	}{
+		{"wildcard", "q=*", []string{"garage", "kitchen"}},
+		{"prefix", "q=" + q(p+"*"), []string{"garage", "kitchen"}},
+		{"narrow prefix", "q=" + q(p+"kit*"), []string{"kitchen"}},
+		{"exact id", "q=" + q(p+"garage"), []string{"garage"}},
+		{"shared label", "q=*&label=floor:ground", []string{"garage", "kitchen"}},
+		{"two labels AND", "q=*&label=room:kitchen&label=floor:ground", []string{"kitchen"}},
+		{"two labels one mismatch", "q=*&label=room:garage&label=floor:upstairs", nil},
+		{"plugin filter", "q=*&plugin_id=plugin-test-slow", []string{"garage"}},
+		{"plugin filter and label", "q=*&plugin_id=plugin-test-clean&label=room:garage", nil},
+	}
+	for _, tc := range deviceCases {
+		t.Run("devices: "+tc.name, func(t *testing.T) {
+			results, err := testutil.SearchDevices(client, tc.query)
+			if err != nil {
+				t.Fatalf("search %q: %v", tc.query, err)
+			}
+			ids := make([]string, 0, len(results))
+			for _, d := range results {
+				ids = append(ids, d.ID)
+			}
+			assertCorpusIDs(t, tc.query, p, ids, tc.want)
+		})
+	}
+
+	entityCases := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"wildcard", "q=*", []string{"door", "light", "sensor"}},
+		{"prefix", "q=" + q(p+"*"), []string{"door", "light", "sensor"}},
+		{"domain filter", "q=*&domain=switch", []string{"door", "light"}},
+		{"other domain", "q=*&domain=sensor", []string{"sensor"}},
+		{"label", "label=group:lights", []string{"light"}},
+		{"label and domain", "q=*&domain=switch&label=group:doors", []string{"door"}},
+		{"plugin filter", "q=*&plugin_id=plugin-test-clean", []string{"light", "sensor"}},
+		{"unknown domain", "q=*&domain=not-a-real-domain", nil},
+	}
+	for _, tc := range entityCases {
+		t.Run("entities: "+tc.name, func(t *testing.T) {
+			results, err := testutil.SearchEntities(client, tc.query)
+			if err != nil {
+				t.Fatalf("search %q: %v", tc.query, err)
+			}
+			ids := make([]string, 0, len(results))
+			for _, e := range results {
+				ids = append(ids, e.ID)
+			}
+			assertCorpusIDs(t, tc.query, p, ids, tc.want)
+		})
+	}
+
+	// Empty and malformed parameters may be rejected with 400 or answered
+	// with a (possibly empty) JSON array, but must never fail server-side.
+	malformed := []string{
+		"",
+		"q=",
+		"q=%2A%2A",
+		"q=" + q("["),
+		"q=" + q(`\`),
+		"label=",
+		"label=nocolon",
+		"label=:",
+		"label=" + q("room:kitchen:extra"),
+		"domain=",
+		"plugin_id=",
+		"plugin_id=" + q("../etc"),
+		"limit=-1",
+		"limit=abc",
+		"q=*&q=*",
+	}
+	for _, kind := range []string{"devices", "entities", "plugins"} {
+		for _, query := range malformed {
+			t.Run(fmt.Sprintf("%s: malformed %q", kind, query), func(t *testing.T) {
+				status, err := testutil.SearchStatus(client, kind, query)
+				if err != nil {
+					t.Fatalf("search %q: %v", query, err)
+				}
+				if status != http.StatusOK && status != http.StatusBadRequest {
+					t.Errorf("search %s?%s: got %d, want 200 or 400", kind, query, status)
+				}
+			})
+		}
+	}
+}
+
+// assertCorpusIDs compares the corpus members of got with want.
+func assertCorpusIDs(t *testing.T, query, prefix string, got, want []string) {
+	t.Helper()
+	inCorpus := make([]string, 0, len(got))
+	for _, id := range got {
+		if strings.HasPrefix(id, prefix) {
+			inCorpus = append(inCorpus, strings.TrimPrefix(id, prefix))
+		}
+	}
+	sort.Strings(inCorpus)
+	sort.Strings(want)
+	if strings.Join(inCorpus, ",") != strings.Join(want, ",") {
+		t.Errorf("query %q: got %v, want %v", query, inCorpus, want)
+	}
+}
+
+// FuzzSearchQuery sends arbitrary query strings to the search routes and fails
+// on any 5xx response, dropped connection or timeout.
+func FuzzSearchQuery(f *testing.F) {
+	for _, seed := range []string{
+		"q=*",
+		"q=sq-*",
+		"label=room:kitchen&label=floor:ground",
+		"domain=switch&plugin_id=plugin-test-clean",
+		"label=%ZZ",
+		"q=" + strings.Repeat("*", 512),
+		"label=" + strings.Repeat("a:", 256),
+	} {
+		f.Add("devices", seed)
+		f.Add("entities", seed)
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	f.Fuzz(func(t *testing.T, kind, rawQuery string) {
+		switch kind {
+		case "devices", "entities", "plugins":
+		default:
+			kind = "devices"
+		}
+		u, err := url.Parse(testutil.SearchURL(kind, ""))
+		if err != nil {
+			t.Fatalf("parse search URL: %v", err)
+		}
+		u.RawQuery = rawQuery
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return // not a request a client could send
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			// A dropped connection is how net/http surfaces a handler
+			// panic, and a timeout is a hang: both fail the input.
+			t.Fatalf("search %s?%q: %v (gateway healthy afterwards: %v)", kind, rawQuery, err, testutil.WaitForPlugin("gateway", 2*time.Second))
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			t.Fatalf("search %s?%s returned %d", kind, rawQuery, resp.StatusCode)
+		}
+	})
+}
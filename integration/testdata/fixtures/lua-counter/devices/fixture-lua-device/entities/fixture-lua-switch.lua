@@ -0,0 +1,9 @@
+function OnInit(Ctx)
+  Ctx:OnCommand("plugin-automation-fixture.fixture-lua-device.fixture-lua-switch.PowerOn", "DoPowerOn")
+end
+
+function DoPowerOn(Ctx, Command)
+  local c = Ctx:GetState("press_count")
+  if c == nil then c = 0 end
+  Ctx:SetState("press_count", c + 1)
+end
@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+// UndeclaredAction is sent to entities whose domain has no command left
+// outside their declared actions, so every entity gets a rejection case.
+const UndeclaredAction = "undeclared_action"
+
+// ActionCase is one command to send to an entity: a declared action with a
+// payload built from its domain descriptor, or an action the entity does not
+// declare, which must be rejected.
+type ActionCase struct {
+	PluginID string
+	DeviceID string
+	EntityID string
+	Domain   string
+	Action   string
+	Payload  map[string]any
+	Declared bool
+	// Opposite is a declared action that undoes Action, such as disable for
+	// enable, with its payload. Sending it first makes sure Action has
+	// something to change. Empty when the entity declares none.
+	Opposite        string
+	OppositePayload map[string]any
+	// Err is set when no payload could be built for a declared action, or
+	// the domain's schema could not be fetched.
+	Err error
+}
+
+// oppositeActions pairs actions that undo each other.
+var oppositeActions = map[string]string{
+	"turn_on": "turn_off",
+	"enable":  "disable",
+	"open":    "close",
+	"lock":    "unlock",
+	"start":   "stop",
+	"arm":     "disarm",
+	"PowerOn": "PowerOff",
+}
+
+// OppositeAction returns the action among declared that undoes action.
+func OppositeAction(action string, declared []string) (string, bool) {
+	for a, b := range oppositeActions {
+		var other string
+		switch action {
+		case a:
+			other = b
+		case b:
+			other = a
+		default:
+			continue
+		}
+		if slices.Contains(declared, other) {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+// Name identifies the case in subtest names.
+func (c ActionCase) Name() string {
+	return c.DeviceID + "/" + c.EntityID + "/" + c.Action
+}
+
+// ActionCases lists every entity of a plugin and returns a case for each of
+// its declared actions, followed by one undeclared action per entity: a
+// command of its domain it does not declare, or UndeclaredAction. Entities
+// for which skip returns true are left out; skip may be nil.
+func ActionCases(client http.Client, pluginID string, skip func(types.Entity) bool) ([]ActionCase, error) {
+	devices, err := ListDevices(client, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	var cases []ActionCase
+	for _, d := range devices {
+		entities, err := ListEntities(client, pluginID, d.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entities {
+			if skip != nil && skip(e) {
+				continue
+			}
+			desc, _, schemaErr := DomainSchema(client, e.Domain)
+			base := ActionCase{PluginID: pluginID, DeviceID: d.ID, EntityID: e.ID, Domain: e.Domain}
+			for _, action := range e.Actions {
+				c := base
+				c.Action, c.Declared = action, true
+				if schemaErr != nil {
+					c.Err = fmt.Errorf("schema for domain %q: %w", e.Domain, schemaErr)
+					cases = append(cases, c)
+					continue
+				}
+				c.Payload, c.Err = ActionPayload(desc, action)
+				if opposite, ok := OppositeAction(action, e.Actions); ok && c.Err == nil {
+					c.Opposite = opposite
+					c.OppositePayload, c.Err = ActionPayload(desc, opposite)
+				}
+				cases = append(cases, c)
+			}
+			c := base
+			c.Action = undeclaredAction(desc, e.Actions)
+			c.Payload = map[string]any{"type": c.Action}
+			cases = append(cases, c)
+		}
+	}
+	return cases, nil
+}
+
+// ActionPayload builds a command payload for action from the domain's
+// descriptor, filling each required field with a value of its declared type.
+func ActionPayload(desc types.DomainDescriptor, action string) (map[string]any, error) {
+	for _, cmd := range desc.Commands {
+		if cmd.Action != action {
+			continue
+		}
+		payload := map[string]any{"type": action}
+		for _, f := range cmd.Fields {
+			if !f.Required {
+				continue
+			}
+			v, ok := sampleValue(f.Type)
+			if !ok {
+				return nil, fmt.Errorf("%s %s: no sample value for field %q of type %q", desc.Domain, action, f.Name, f.Type)
+			}
+			payload[f.Name] = v
+		}
+		return payload, nil
+	}
+	return nil, fmt.Errorf("domain %q has no command %q", desc.Domain, action)
+}
+
+func undeclaredAction(desc types.DomainDescriptor, declared []string) string {
+	for _, cmd := range desc.Commands {
+		if !slices.Contains(declared, cmd.Action) {
+			return cmd.Action
+		}
+	}
+	return UndeclaredAction
+}
+
+func sampleValue(typ string) (any, bool) {
+	switch strings.ToLower(typ) {
+	case "bool", "boolean":
+		return true, true
+	case "number", "float", "float64":
+		return 0.5, true
+	case "int", "integer":
+		return 1, true
+	case "string":
+		return "test", true
+	case "object", "map":
+		return map[string]any{}, true
+	case "array", "list":
+		return []any{}, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,30 @@
+package testutil
+
+import (
+	"net/http"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+// PluginURL returns the gateway base route for a plugin.
+func PluginURL(pluginID string) string {
+	return APIBaseURL() + "/api/plugins/" + pluginID
+}
+
+// ListDevices returns the devices a plugin serves through the gateway.
+func ListDevices(client http.Client, pluginID string) ([]types.Device, error) {
+	var devices []types.Device
+	if err := getJSON(client, PluginURL(pluginID)+"/devices", &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// ListEntities returns the entities of one device of a plugin.
+func ListEntities(client http.Client, pluginID, deviceID string) ([]types.Entity, error) {
+	var entities []types.Entity
+	if err := getJSON(client, PluginURL(pluginID)+"/devices/"+deviceID+"/entities", &entities); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
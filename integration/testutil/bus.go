@@ -0,0 +1,95 @@
+package testutil
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/slidebolt/sdk-types"
+)
+
+// EntityEventsSubject is the bus subject plugins publish entity events on.
+const EntityEventsSubject = "slidebolt.entity.events"
+
+// BusEvent is an entity event observed on the bus. CommandID is set when the
+// event was produced in response to a command; ReceivedAt is the local time
+// the subscriber saw the message.
+type BusEvent struct {
+	types.EntityEventEnvelope
+	CommandID  string    `json:"command_id,omitempty"`
+	ReceivedAt time.Time `json:"-"`
+}
+
+// NATSURL returns the bus URL from NATS_URL or TEST_NATS_URL, or "" when
+// neither is set.
+func NATSURL() string {
+	for _, key := range []string{"NATS_URL", "TEST_NATS_URL"} {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ConnectBus connects to the test bus and closes the connection when the test
+// ends. Tests are skipped when no bus URL is configured.
+func ConnectBus(t testing.TB) *nats.Conn {
+	t.Helper()
+	url := NATSURL()
+	if url == "" {
+		t.Skip("no NATS URL configured (set NATS_URL or TEST_NATS_URL)")
+	}
+	nc, err := nats.Connect(url)
+	if err != nil {
+		t.Fatalf("failed to connect to NATS at %s: %v", url, err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// SubscribeEntityEvents delivers every entity event matching the given IDs to
+// fn. Empty IDs match anything.
+func SubscribeEntityEvents(t testing.TB, nc *nats.Conn, pluginID, deviceID, entityID string, fn func(BusEvent)) {
+	t.Helper()
+	sub, err := nc.Subscribe(EntityEventsSubject, func(msg *nats.Msg) {
+		received := time.Now()
+		var ev BusEvent
+		if err := json.Unmarshal(msg.Data, &ev); err != nil {
+			return
+		}
+		if pluginID != "" && ev.PluginID != pluginID {
+			return
+		}
+		if deviceID != "" && ev.DeviceID != deviceID {
+			return
+		}
+		if entityID != "" && ev.EntityID != entityID {
+			return
+		}
+		ev.ReceivedAt = received
+		fn(ev)
+	})
+	if err != nil {
+		t.Fatalf("subscribe %s failed: %v", EntityEventsSubject, err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("flush after subscribe failed: %v", err)
+	}
+	t.Cleanup(func() { _ = sub.Unsubscribe() })
+}
+
+// PublishEntityEvent publishes ev on the entity events subject as if a plugin
+// had emitted it.
+func PublishEntityEvent(t testing.TB, nc *nats.Conn, ev types.EntityEventEnvelope) {
+	t.Helper()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatalf("marshal event %s: %v", ev.EventID, err)
+	}
+	if err := nc.Publish(EntityEventsSubject, data); err != nil {
+		t.Fatalf("publish event %s: %v", ev.EventID, err)
+	}
+}
@@ -0,0 +1,98 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+// CommandURL returns the gateway route for sending commands to an entity.
+func CommandURL(pluginID, deviceID, entityID string) string {
+	return fmt.Sprintf("%s/api/plugins/%s/devices/%s/entities/%s/commands", APIBaseURL(), pluginID, deviceID, entityID)
+}
+
+// PostCommand sends payload to an entity and returns the status the gateway
+// acknowledged it with. Any response other than 202 is an error.
+func PostCommand(client http.Client, pluginID, deviceID, entityID string, payload any) (types.CommandStatus, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return types.CommandStatus{}, err
+	}
+	resp, err := client.Post(CommandURL(pluginID, deviceID, entityID), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return types.CommandStatus{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return types.CommandStatus{}, fmt.Errorf("status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	var status types.CommandStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return types.CommandStatus{}, fmt.Errorf("decode command status: %w", err)
+	}
+	return status, nil
+}
+
+// CommandStatusURL returns the gateway route for polling a command's status.
+func CommandStatusURL(pluginID, commandID string) string {
+	return fmt.Sprintf("%s/api/plugins/%s/commands/%s", APIBaseURL(), pluginID, commandID)
+}
+
+// GetCommandStatus fetches the current status of a command.
+func GetCommandStatus(client http.Client, pluginID, commandID string) (types.CommandStatus, error) {
+	var status types.CommandStatus
+	err := getJSON(client, CommandStatusURL(pluginID, commandID), &status)
+	return status, err
+}
+
+// WaitForCommand polls a command until it leaves the pending state and
+// returns its final status.
+func WaitForCommand(t testing.TB, client http.Client, status types.CommandStatus, timeout time.Duration) types.CommandStatus {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if status.State != types.CommandPending && status.State != "" {
+			return status
+		}
+		time.Sleep(100 * time.Millisecond)
+		next, err := GetCommandStatus(client, status.PluginID, status.CommandID)
+		if err == nil {
+			status = next
+		}
+	}
+	t.Fatalf("command %s on %s/%s/%s still %q after %v", status.CommandID, status.PluginID, status.DeviceID, status.EntityID, status.State, timeout)
+	return status
+}
+
+// ExpectCommandRejected sends payload and fails unless the gateway or the
+// plugin rejects it. If field is set, the error must mention it.
+func ExpectCommandRejected(t testing.TB, client http.Client, pluginID, deviceID, entityID string, payload any, field string) {
+	t.Helper()
+	status, err := PostCommand(client, pluginID, deviceID, entityID, payload)
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	} else {
+		final := WaitForCommand(t, client, status, 5*time.Second)
+		if final.State != types.CommandFailed {
+			t.Errorf("payload %v accepted by %s/%s/%s (state %q)", payload, pluginID, deviceID, entityID, final.State)
+			return
+		}
+		msg = final.Error
+	}
+	if strings.TrimSpace(msg) == "" {
+		t.Errorf("payload %v rejected without an error message", payload)
+		return
+	}
+	if field != "" && !strings.Contains(msg, field) {
+		t.Errorf("rejection of %v does not name %q: %s", payload, field, msg)
+	}
+}
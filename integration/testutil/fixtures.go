@@ -0,0 +1,234 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+// Fixture is the device and entity content of a data-directory snapshot, as
+// laid out by the runner: devices/{id}.json and
+// devices/{id}/entities/{entity}.json. Lua scripts and their
+// .state.lua.json files are copied by SeedDataDir but not parsed here.
+type Fixture struct {
+	Dir      string
+	Devices  []types.Device
+	Entities map[string][]types.Entity // keyed by device ID
+}
+
+// FixtureDir locates testdata/fixtures/{name}, searching upward from the
+// working directory so plugin suites can share the top-level fixtures.
+func FixtureDir(t testing.TB, name string) string {
+	t.Helper()
+	dir, ok := FindFixtureDir(name)
+	if !ok {
+		wd, _ := os.Getwd()
+		t.Fatalf("fixture %q not found under any testdata/fixtures from %s", name, wd)
+	}
+	return dir
+}
+
+// FindFixtureDir is FixtureDir without failing: it reports false when no
+// testdata/fixtures/{name} directory exists.
+func FindFixtureDir(name string) (string, bool) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	path := wd
+	for i := 0; i < 8; i++ {
+		candidate := filepath.Join(path, "testdata", "fixtures", name)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate, true
+		}
+		next := filepath.Dir(path)
+		if next == path {
+			break
+		}
+		path = next
+	}
+	return "", false
+}
+
+// SeedDataDir copies every file in fixtureDir into dataDir, preserving the
+// directory layout. It must run before the plugin starts.
+func SeedDataDir(t testing.TB, fixtureDir, dataDir string) {
+	t.Helper()
+	if err := CopyDir(fixtureDir, dataDir); err != nil {
+		t.Fatalf("seed %s from fixture %s: %v", dataDir, fixtureDir, err)
+	}
+}
+
+// CopyDir copies every file under src into dst, preserving the directory
+// layout.
+func CopyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0o755)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// LoadFixture parses the devices and entities in a fixture directory.
+func LoadFixture(t testing.TB, dir string) Fixture {
+	t.Helper()
+	fx := Fixture{Dir: dir, Entities: map[string][]types.Entity{}}
+	deviceFiles, _ := filepath.Glob(filepath.Join(dir, "devices", "*.json"))
+	sort.Strings(deviceFiles)
+	for _, path := range deviceFiles {
+		var dev types.Device
+		readFixtureJSON(t, path, &dev)
+		fx.Devices = append(fx.Devices, dev)
+	}
+	entityFiles, _ := filepath.Glob(filepath.Join(dir, "devices", "*", "entities", "*.json"))
+	sort.Strings(entityFiles)
+	for _, path := range entityFiles {
+		if strings.HasSuffix(path, ".state.lua.json") {
+			continue
+		}
+		deviceID := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		var ent types.Entity
+		readFixtureJSON(t, path, &ent)
+		if ent.DeviceID == "" {
+			// Entity files that omit device_id belong to their directory.
+			ent.DeviceID = deviceID
+		}
+		fx.Entities[deviceID] = append(fx.Entities[deviceID], ent)
+	}
+	return fx
+}
+
+func readFixtureJSON(t testing.TB, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read fixture file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("fixture file %s invalid JSON: %v", path, err)
+	}
+}
+
+// AssertFixtureLoaded checks through the devices and entities list routes
+// that pluginID serves every device and entity in fx with its identifying
+// and naming fields intact.
+func AssertFixtureLoaded(t testing.TB, pluginID string, fx Fixture) {
+	t.Helper()
+	client := http.Client{Timeout: 2 * time.Second}
+	base := PluginURL(pluginID)
+
+	var devices []types.Device
+	if err := getJSON(client, base+"/devices", &devices); err != nil {
+		t.Fatalf("list devices for %s: %v", pluginID, err)
+	}
+	byID := make(map[string]types.Device, len(devices))
+	for _, d := range devices {
+		byID[d.ID] = d
+	}
+	for _, want := range fx.Devices {
+		got, ok := byID[want.ID]
+		if !ok {
+			t.Errorf("fixture device %q not loaded by %s", want.ID, pluginID)
+			continue
+		}
+		if diff := diffDevice(want, got); diff != "" {
+			t.Errorf("fixture device %q loaded with changes: %s", want.ID, diff)
+		}
+	}
+
+	for deviceID, wantEntities := range fx.Entities {
+		var entities []types.Entity
+		if err := getJSON(client, base+"/devices/"+deviceID+"/entities", &entities); err != nil {
+			t.Errorf("list entities for %s/%s: %v", pluginID, deviceID, err)
+			continue
+		}
+		byID := make(map[string]types.Entity, len(entities))
+		for _, e := range entities {
+			byID[e.ID] = e
+		}
+		for _, want := range wantEntities {
+			got, ok := byID[want.ID]
+			if !ok {
+				t.Errorf("fixture entity %s/%s not loaded by %s", deviceID, want.ID, pluginID)
+				continue
+			}
+			if diff := diffEntity(want, got); diff != "" {
+				t.Errorf("fixture entity %s/%s loaded with changes: %s", deviceID, want.ID, diff)
+			}
+		}
+	}
+}
+
+func diffDevice(want, got types.Device) string {
+	var diffs []string
+	diffs = appendDiff(diffs, "source_id", want.SourceID, got.SourceID)
+	diffs = appendDiff(diffs, "source_name", want.SourceName, got.SourceName)
+	diffs = appendDiff(diffs, "local_name", want.LocalName, got.LocalName)
+	diffs = appendDiff(diffs, "labels", fmt.Sprint(want.Labels), fmt.Sprint(got.Labels))
+	return strings.Join(diffs, "; ")
+}
+
+func diffEntity(want, got types.Entity) string {
+	var diffs []string
+	diffs = appendDiff(diffs, "device_id", want.DeviceID, got.DeviceID)
+	diffs = appendDiff(diffs, "domain", want.Domain, got.Domain)
+	diffs = appendDiff(diffs, "local_name", want.LocalName, got.LocalName)
+	diffs = appendDiff(diffs, "actions", fmt.Sprint(want.Actions), fmt.Sprint(got.Actions))
+	diffs = appendDiff(diffs, "labels", fmt.Sprint(want.Labels), fmt.Sprint(got.Labels))
+	return strings.Join(diffs, "; ")
+}
+
+func appendDiff(diffs []string, field, want, got string) []string {
+	if want == got {
+		return diffs
+	}
+	return append(diffs, fmt.Sprintf("%s: got %q, want %q", field, got, want))
+}
+
+func getJSON(client http.Client, url string, v any) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
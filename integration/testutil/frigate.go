@@ -0,0 +1,43 @@
+package testutil
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/slidebolt/testrunner/local/frigate"
+)
+
+// StartFrigateMock starts a Frigate mock with the given cameras whose
+// recorded requests have credentials redacted, and closes it when t ends.
+func StartFrigateMock(t testing.TB, cameras ...frigate.Camera) *frigate.Server {
+	t.Helper()
+	s := frigate.NewServer(cameras...)
+	s.SetRecordFilter(RedactRequest)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// RedactRequest redacts a recorded request's credential headers and any
+// query parameter that names a secret or carries a registered one.
+func RedactRequest(r frigate.Request) frigate.Request {
+	r.Header = RedactHeader(r.Header)
+	r.Query = redactQuery(r.Query)
+	return r
+}
+
+func redactQuery(raw string) string {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return Redact(raw)
+	}
+	for k, vs := range q {
+		for i, v := range vs {
+			if IsSecretKey(k) {
+				vs[i] = Redacted
+			} else {
+				vs[i] = Redact(v)
+			}
+		}
+	}
+	return q.Encode()
+}
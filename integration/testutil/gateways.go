@@ -0,0 +1,228 @@
+package testutil
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	runner "github.com/slidebolt/sdk-runner"
+)
+
+// GatewayOptions describes a gateway process launched by the test itself,
+// next to the shared runtime's. The binary is started with NATS_URL and its
+// listen address in the variable named by TEST_GATEWAY_ADDR_ENV (default
+// API_ADDR), plus Env.
+type GatewayOptions struct {
+	// Binary is the gateway executable; see GatewayBinary.
+	Binary string
+	// Name labels the gateway in failures and logs.
+	Name string
+	// Env holds extra KEY=VALUE entries appended after the defaults.
+	Env []string
+}
+
+// GatewayProcess is a harness-owned gateway. It is stopped when the test
+// ends.
+type GatewayProcess struct {
+	opts GatewayOptions
+	addr string
+
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	done chan struct{}
+	logs *lockedBuffer
+}
+
+// GatewayBinary returns the path of the built gateway executable:
+// TEST_GATEWAY_BINARY if set, otherwise "gateway" looked up like
+// PluginBinary. Returns "" if it cannot be found.
+func GatewayBinary() string {
+	if path := strings.TrimSpace(os.Getenv("TEST_GATEWAY_BINARY")); path != "" {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		return ""
+	}
+	return PluginBinary("gateway")
+}
+
+// StartGateway launches a gateway on a free loopback port and waits for it
+// to report healthy. The test is skipped if the binary or the bus is missing
+// and fails if the gateway does not come up.
+func StartGateway(t testing.TB, opts GatewayOptions) *GatewayProcess {
+	t.Helper()
+	if opts.Binary == "" {
+		t.Skip("gateway binary not found; set TEST_GATEWAY_BINARY or TEST_PLUGIN_BIN_DIR")
+	}
+	if NATSURL() == "" {
+		t.Skip("gateways need a shared bus; set NATS_URL or TEST_NATS_URL")
+	}
+	if opts.Name == "" {
+		opts.Name = "gateway"
+	}
+	addr, err := freeAddr()
+	if err != nil {
+		t.Fatalf("reserve address for %s: %v", opts.Name, err)
+	}
+	RegisterSecretEnv(opts.Env)
+	g := &GatewayProcess{opts: opts, addr: addr, logs: &lockedBuffer{}}
+	t.Cleanup(func() {
+		g.Stop()
+		if t.Failed() {
+			t.Logf("%s output:\n%s", opts.Name, Redact(g.logs.String()))
+		}
+	})
+	g.Start(t)
+	return g
+}
+
+// StartGateways launches n harness-owned gateways on the shared bus.
+func StartGateways(t testing.TB, n int) []*GatewayProcess {
+	t.Helper()
+	gateways := make([]*GatewayProcess, n)
+	for i := range gateways {
+		gateways[i] = StartGateway(t, GatewayOptions{
+			Binary: GatewayBinary(),
+			Name:   fmt.Sprintf("gateway-%d", i),
+		})
+	}
+	return gateways
+}
+
+// URL returns the gateway's base URL.
+func (g *GatewayProcess) URL() string { return "http://" + g.addr }
+
+// Running reports whether the process is alive.
+func (g *GatewayProcess) Running() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.done == nil {
+		return false
+	}
+	select {
+	case <-g.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Start (re)launches the process on the same address and waits for it to
+// become healthy.
+func (g *GatewayProcess) Start(t testing.TB) {
+	t.Helper()
+	if g.Running() {
+		t.Fatalf("%s already running", g.opts.Name)
+	}
+
+	addrEnv := strings.TrimSpace(os.Getenv("TEST_GATEWAY_ADDR_ENV"))
+	if addrEnv == "" {
+		addrEnv = "API_ADDR"
+	}
+	cmd := exec.Command(g.opts.Binary)
+	cmd.Env = append(os.Environ(),
+		addrEnv+"="+g.addr,
+		"NATS_URL="+NATSURL(),
+	)
+	cmd.Env = append(cmd.Env, g.opts.Env...)
+	cmd.Stdout = g.logs
+	cmd.Stderr = g.logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start %s (%s): %v", g.opts.Name, g.opts.Binary, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+	g.mu.Lock()
+	g.cmd, g.done = cmd, done
+	g.mu.Unlock()
+
+	if !WaitForGateway(g.URL(), 15*time.Second) || !g.Running() {
+		t.Fatalf("%s did not become healthy on %s after start", g.opts.Name, g.addr)
+	}
+}
+
+// Kill sends SIGKILL, simulating a crash, and waits for the process to exit.
+func (g *GatewayProcess) Kill() {
+	g.signal(syscall.SIGKILL, 0)
+}
+
+// Stop asks the process to exit with SIGTERM and kills it if it has not
+// exited within five seconds.
+func (g *GatewayProcess) Stop() {
+	g.signal(syscall.SIGTERM, 5*time.Second)
+}
+
+func (g *GatewayProcess) signal(sig syscall.Signal, grace time.Duration) {
+	g.mu.Lock()
+	cmd, done := g.cmd, g.done
+	g.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	select {
+	case <-done:
+		return
+	default:
+	}
+	_ = cmd.Process.Signal(sig)
+	if grace > 0 {
+		select {
+		case <-done:
+			return
+		case <-time.After(grace):
+			_ = cmd.Process.Kill()
+		}
+	}
+	<-done
+}
+
+// Logs returns everything the process has written to stdout and stderr.
+func (g *GatewayProcess) Logs() string { return g.logs.String() }
+
+// OnGateway rebases a URL built by this package's helpers (CommandURL,
+// SearchURL, JournalURL, ...) onto another gateway.
+func OnGateway(base, url string) string {
+	return strings.TrimRight(base, "/") + strings.TrimPrefix(url, APIBaseURL())
+}
+
+// WaitForGateway polls a gateway's health endpoint until it reports 200.
+func WaitForGateway(base string, timeout time.Duration) bool {
+	client := http.Client{Timeout: 500 * time.Millisecond}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(strings.TrimRight(base, "/") + runner.HealthEndpoint)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return true
+			}
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// freeAddr returns a loopback address with a port that was free a moment
+// ago. The gateway binds it itself, so another process could take it first;
+// the gateway then exits and Start fails.
+func freeAddr() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
@@ -0,0 +1,18 @@
+package testutil
+
+import "regexp"
+
+// idPattern is the accepted grammar for plugin, device and entity IDs:
+// letters, digits, '-', '_' and ':', starting with a letter or digit, at
+// most 128 characters. Case and colons are allowed because plugins such as
+// plugin-kasa use a device's MAC address ("AA:BB:CC:DD:EE:FF") as its ID;
+// IDs that differ only in case still name the same object. Dots are excluded
+// because Lua addresses commands as plugin.device.entity.Action; slashes and
+// spaces because IDs are path segments and file names.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_:-]{0,127}$`)
+
+// IDPattern returns the accepted ID grammar as a regular expression.
+func IDPattern() string { return idPattern.String() }
+
+// ValidID reports whether id matches the accepted ID grammar.
+func ValidID(id string) bool { return idPattern.MatchString(id) }
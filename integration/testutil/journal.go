@@ -0,0 +1,103 @@
+package testutil
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// JournalEvent is one entry from /api/journal/events.
+type JournalEvent struct {
+	Name      string    `json:"name"`
+	PluginID  string    `json:"plugin_id"`
+	DeviceID  string    `json:"device_id"`
+	EntityID  string    `json:"entity_id"`
+	EventID   string    `json:"event_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JournalQuery holds the journal route's filters. Zero values are omitted.
+type JournalQuery struct {
+	PluginID string
+	DeviceID string
+	EntityID string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+// Values encodes q as journal query parameters.
+func (q JournalQuery) Values() url.Values {
+	v := url.Values{}
+	if q.PluginID != "" {
+		v.Set("plugin_id", q.PluginID)
+	}
+	if q.DeviceID != "" {
+		v.Set("device_id", q.DeviceID)
+	}
+	if q.EntityID != "" {
+		v.Set("entity_id", q.EntityID)
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.UTC().Format(time.RFC3339Nano))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return v
+}
+
+// JournalURL returns the journal route for q.
+func JournalURL(q JournalQuery) string {
+	u := APIBaseURL() + "/api/journal/events"
+	if enc := q.Values().Encode(); enc != "" {
+		u += "?" + enc
+	}
+	return u
+}
+
+// JournalEvents fetches the journal entries matching q, in the order the
+// gateway returns them.
+func JournalEvents(client http.Client, q JournalQuery) ([]JournalEvent, error) {
+	var events []JournalEvent
+	if err := getJSON(client, JournalURL(q), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// WaitForJournal polls the journal until done returns true for the entries
+// matching q, and returns those entries. The test fails on timeout.
+func WaitForJournal(t testing.TB, client http.Client, q JournalQuery, timeout time.Duration, done func([]JournalEvent) bool) []JournalEvent {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	var (
+		events []JournalEvent
+		err    error
+	)
+	for time.Now().Before(deadline) {
+		events, err = JournalEvents(client, q)
+		if err == nil && done(events) {
+			return events
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("journal %s not ready within %s: %v", JournalURL(q), timeout, err)
+	}
+	t.Fatalf("journal %s not ready within %s (%d entries)", JournalURL(q), timeout, len(events))
+	return nil
+}
+
+// LatestJournalEvent waits for at least one entry matching q and returns the
+// last one.
+func LatestJournalEvent(t testing.TB, client http.Client, q JournalQuery, timeout time.Duration) JournalEvent {
+	t.Helper()
+	events := WaitForJournal(t, client, q, timeout, func(events []JournalEvent) bool { return len(events) > 0 })
+	return events[len(events)-1]
+}
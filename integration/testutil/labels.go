@@ -0,0 +1,61 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// PutDeviceLabels sends a PUT carrying only the device ID and labels. Unlike
+// marshalling a types.Device, a non-nil empty map is sent as "labels": {}
+// and a nil map as "labels": null, so clearing can be told apart from
+// omission.
+func PutDeviceLabels(client http.Client, pluginID, deviceID string, labels map[string]string) error {
+	return putJSON(client, PluginURL(pluginID)+"/devices", map[string]any{"id": deviceID, "labels": labels})
+}
+
+// PutEntityLabels is PutDeviceLabels for an entity.
+func PutEntityLabels(client http.Client, pluginID, deviceID, entityID string, labels map[string]string) error {
+	return putJSON(client, PluginURL(pluginID)+"/devices/"+deviceID+"/entities", map[string]any{"id": entityID, "device_id": deviceID, "labels": labels})
+}
+
+// LabelQuery encodes labels as label=key:value search parameters, sorted by
+// key.
+func LabelQuery(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	v := url.Values{}
+	for _, k := range keys {
+		v.Add("label", k+":"+labels[k])
+	}
+	return v.Encode()
+}
+
+func putJSON(client http.Client, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("PUT %s: status %d: %s", url, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
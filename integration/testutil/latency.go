@@ -0,0 +1,109 @@
+package testutil
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyRecorder correlates sent commands with the events they produce.
+// It is safe for concurrent use so bus callbacks can record observations
+// while commands are still being sent.
+type LatencyRecorder struct {
+	mu       sync.Mutex
+	sent     map[string]time.Time
+	observed map[string][]time.Time
+}
+
+// LatencySummary is the outcome of a recording window.
+type LatencySummary struct {
+	Sent       int
+	Matched    int
+	Dropped    int // commands with no corresponding event
+	Duplicated int // extra events beyond the first for a command
+	Unknown    int // events carrying a command ID that was never sent
+	P50        time.Duration
+	P95        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+func NewLatencyRecorder() *LatencyRecorder {
+	return &LatencyRecorder{
+		sent:     map[string]time.Time{},
+		observed: map[string][]time.Time{},
+	}
+}
+
+// Sent records that commandID was sent at ts.
+func (r *LatencyRecorder) Sent(commandID string, ts time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent[commandID] = ts
+}
+
+// Observed records an event for commandID received at ts. A fast plugin can
+// answer before Sent is called, so matching is deferred until Summary.
+func (r *LatencyRecorder) Observed(commandID string, ts time.Time) {
+	if commandID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.observed[commandID] = append(r.observed[commandID], ts)
+}
+
+// Summary computes percentiles over every matched command.
+func (r *LatencyRecorder) Summary() LatencySummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := LatencySummary{Sent: len(r.sent)}
+	latencies := make([]time.Duration, 0, len(r.sent))
+	for id, sentAt := range r.sent {
+		seen := r.observed[id]
+		if len(seen) == 0 {
+			out.Dropped++
+			continue
+		}
+		first := seen[0]
+		for _, ts := range seen[1:] {
+			if ts.Before(first) {
+				first = ts
+			}
+		}
+		out.Matched++
+		out.Duplicated += len(seen) - 1
+		latencies = append(latencies, first.Sub(sentAt))
+	}
+	for id := range r.observed {
+		if _, ok := r.sent[id]; !ok {
+			out.Unknown++
+		}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	out.P50 = Percentile(latencies, 50)
+	out.P95 = Percentile(latencies, 95)
+	out.P99 = Percentile(latencies, 99)
+	if len(latencies) > 0 {
+		out.Max = latencies[len(latencies)-1]
+	}
+	return out
+}
+
+// Percentile returns the nearest-rank percentile p (0-100) of sorted.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
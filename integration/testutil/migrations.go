@@ -0,0 +1,169 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+// MigrationFixtures is the testdata/fixtures subdirectory holding one
+// recorded data dir per earlier sdk-types release, as
+// migrations/{release}/data and migrations/{release}/release.json. They are
+// recorded, never written by hand, with cmd/record-migration-fixture.
+const MigrationFixtures = "migrations"
+
+// MigrationRecording is release.json: the release a fixture was recorded
+// with and exactly what the plugin served for each device and entity. Served
+// values are kept raw so the keys the release knew about are known.
+type MigrationRecording struct {
+	SDKTypes string                                `json:"sdk_types"`
+	Devices  map[string]json.RawMessage            `json:"devices"`
+	Entities map[string]map[string]json.RawMessage `json:"entities"` // device ID → entity ID → entity
+}
+
+// MigrationSeedDevice and MigrationSeedEntity are what the recorder creates.
+// They set every field the current types have; a release persists whichever
+// of them it knows.
+var (
+	MigrationSeedDevice = types.Device{
+		ID:         "migrate-device",
+		SourceID:   "src-migrate",
+		SourceName: "Migrate Source",
+		LocalName:  "Migrate Device",
+		Labels:     map[string]string{"room": "migrate-room"},
+	}
+	MigrationSeedEntity = types.Entity{
+		ID:        "migrate-entity",
+		DeviceID:  "migrate-device",
+		Domain:    "switch",
+		LocalName: "Migrate Entity",
+		Actions:   []string{"turn_on", "turn_off"},
+		Labels:    map[string]string{"group": "migrate-lights"},
+	}
+)
+
+// RecordMigration launches binary, a plugin-test-clean built against the
+// sdk-types release named release, on a fresh data dir, creates the seed
+// device and entity through the running gateway, and writes the data dir and
+// what was served to dir/data and dir/release.json.
+func RecordMigration(binary, release, dir string) error {
+	dataDir, err := os.MkdirTemp("", "migration-"+release+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dataDir)
+
+	const pluginID = "plugin-test-clean-record"
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(), "PLUGIN_ID="+pluginID, "PLUGIN_DATA_DIR="+dataDir)
+	if url := NATSURL(); url != "" {
+		cmd.Env = append(cmd.Env, "NATS_URL="+url)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", binary, err)
+	}
+	stopped := false
+	stop := func() error {
+		if stopped {
+			return nil
+		}
+		stopped = true
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case <-done:
+			return nil
+		case <-time.After(5 * time.Second):
+			_ = cmd.Process.Kill()
+			return fmt.Errorf("%s did not stop on SIGTERM", binary)
+		}
+	}
+	defer stop()
+	if !WaitForPlugin(pluginID, 15*time.Second) {
+		return fmt.Errorf("%s did not become healthy", pluginID)
+	}
+
+	client := http.Client{Timeout: 3 * time.Second}
+	base := PluginURL(pluginID)
+	if err := postJSON(client, base+"/devices", MigrationSeedDevice); err != nil {
+		return err
+	}
+	if err := postJSON(client, base+"/devices/"+MigrationSeedDevice.ID+"/entities", MigrationSeedEntity); err != nil {
+		return err
+	}
+
+	rec := MigrationRecording{
+		SDKTypes: release,
+		Devices:  map[string]json.RawMessage{},
+		Entities: map[string]map[string]json.RawMessage{MigrationSeedDevice.ID: {}},
+	}
+	var devices []json.RawMessage
+	if err := getJSON(client, base+"/devices", &devices); err != nil {
+		return fmt.Errorf("list devices: %w", err)
+	}
+	for _, raw := range devices {
+		if rawID(raw) == MigrationSeedDevice.ID {
+			rec.Devices[MigrationSeedDevice.ID] = raw
+		}
+	}
+	var entities []json.RawMessage
+	if err := getJSON(client, base+"/devices/"+MigrationSeedDevice.ID+"/entities", &entities); err != nil {
+		return fmt.Errorf("list entities: %w", err)
+	}
+	for _, raw := range entities {
+		if rawID(raw) == MigrationSeedEntity.ID {
+			rec.Entities[MigrationSeedDevice.ID][MigrationSeedEntity.ID] = raw
+		}
+	}
+	if len(rec.Devices) == 0 || len(rec.Entities[MigrationSeedDevice.ID]) == 0 {
+		return fmt.Errorf("%s did not serve the seeded device and entity", pluginID)
+	}
+	if err := stop(); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := CopyDir(dataDir, filepath.Join(dir, "data")); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "release.json"), append(data, '\n'), 0o644)
+}
+
+func rawID(raw json.RawMessage) string {
+	var v struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(raw, &v)
+	return v.ID
+}
+
+func postJSON(client http.Client, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: expected 200, got %d", url, resp.StatusCode)
+	}
+	return nil
+}
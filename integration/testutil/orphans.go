@@ -0,0 +1,84 @@
+package testutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Orphan is a file or directory in a plugin data dir whose owner is gone.
+type Orphan struct {
+	Path   string
+	Reason string
+}
+
+func (o Orphan) String() string { return o.Path + ": " + o.Reason }
+
+// ScanOrphans walks a plugin data dir and reports entity directories with no
+// parent device file, and Lua scripts or script state with no entity file.
+// A missing devices/ directory is not an error.
+func ScanOrphans(dataDir string) ([]Orphan, error) {
+	devicesDir := filepath.Join(dataDir, "devices")
+	entries, err := os.ReadDir(devicesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var orphans []Orphan
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		deviceID := e.Name()
+		deviceDir := filepath.Join(devicesDir, deviceID)
+		if _, err := os.Stat(filepath.Join(devicesDir, deviceID+".json")); os.IsNotExist(err) {
+			orphans = append(orphans, Orphan{Path: deviceDir, Reason: fmt.Sprintf("no device file %s.json", deviceID)})
+		}
+		entityFiles, err := os.ReadDir(filepath.Join(deviceDir, "entities"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entities := map[string]bool{}
+		for _, f := range entityFiles {
+			name := f.Name()
+			if strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".state.lua.json") {
+				entities[strings.TrimSuffix(name, ".json")] = true
+			}
+		}
+		for _, f := range entityFiles {
+			name := f.Name()
+			var entityID string
+			switch {
+			case strings.HasSuffix(name, ".state.lua.json"):
+				entityID = strings.TrimSuffix(name, ".state.lua.json")
+			case strings.HasSuffix(name, ".lua"):
+				entityID = strings.TrimSuffix(name, ".lua")
+			default:
+				continue
+			}
+			if !entities[entityID] {
+				orphans = append(orphans, Orphan{Path: filepath.Join(deviceDir, "entities", name), Reason: fmt.Sprintf("no entity file %s.json", entityID)})
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// AssertNoOrphans fails t for every orphan ScanOrphans finds in dataDir.
+func AssertNoOrphans(t testing.TB, dataDir string) {
+	t.Helper()
+	orphans, err := ScanOrphans(dataDir)
+	if err != nil {
+		t.Fatalf("scan %s for orphans: %v", dataDir, err)
+	}
+	for _, o := range orphans {
+		t.Errorf("orphan: %s", o)
+	}
+}
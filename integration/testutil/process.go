@@ -0,0 +1,222 @@
+package testutil
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// PluginOptions describes a plugin process launched by the test itself rather
+// than by the shared runtime. The binary is started with the runner's
+// environment contract (PLUGIN_ID, PLUGIN_DATA_DIR, NATS_URL) plus Env.
+type PluginOptions struct {
+	// Binary is the plugin executable; see PluginBinary.
+	Binary string
+	// ID is the plugin ID to register as. Use an ID distinct from the shared
+	// runtime's plugins so the two never contend for the same data dir.
+	ID string
+	// DataDir is the directory the plugin persists to. Defaults to a
+	// per-test temp dir.
+	DataDir string
+	// Env holds extra KEY=VALUE entries appended after the defaults.
+	Env []string
+	// Fixture, if set, is a data-dir snapshot copied into DataDir before the
+	// first launch; see SeedDataDir.
+	Fixture string
+}
+
+// PluginProcess is a harness-owned plugin. It is stopped when the test ends.
+type PluginProcess struct {
+	opts PluginOptions
+
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	done chan struct{}
+	logs *lockedBuffer
+}
+
+// PluginBinary returns the path of a built plugin executable, looked up in
+// TEST_PLUGIN_BIN_DIR or the .build/bin directory next to runtime.json.
+// Returns "" if it cannot be found.
+func PluginBinary(pluginID string) string {
+	dirs := make([]string, 0, 2)
+	if dir := strings.TrimSpace(os.Getenv("TEST_PLUGIN_BIN_DIR")); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if runtimePath, err := findRuntimeFile(); err == nil {
+		dirs = append(dirs, filepath.Join(filepath.Dir(runtimePath), "bin"))
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, pluginID)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// StartPlugin launches a plugin process and waits for it to report healthy.
+// The test is skipped if the binary is missing and fails if the plugin does
+// not come up.
+func StartPlugin(t testing.TB, opts PluginOptions) *PluginProcess {
+	t.Helper()
+	if opts.Binary == "" {
+		t.Skipf("plugin binary for %q not found; set TEST_PLUGIN_BIN_DIR", opts.ID)
+	}
+	if opts.DataDir == "" {
+		opts.DataDir = t.TempDir()
+	}
+	if opts.Fixture != "" {
+		SeedDataDir(t, opts.Fixture, opts.DataDir)
+	}
+	RegisterSecretEnv(opts.Env)
+	p := &PluginProcess{opts: opts, logs: &lockedBuffer{}}
+	t.Cleanup(func() {
+		p.Stop()
+		if t.Failed() {
+			t.Logf("%s output:\n%s", opts.ID, Redact(p.logs.String()))
+		}
+	})
+	p.Start(t)
+	return p
+}
+
+// ID returns the plugin ID the process registers as.
+func (p *PluginProcess) ID() string { return p.opts.ID }
+
+// DataDir returns the directory the plugin persists to.
+func (p *PluginProcess) DataDir() string { return p.opts.DataDir }
+
+// Running reports whether the process is alive.
+func (p *PluginProcess) Running() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done == nil {
+		return false
+	}
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// Start (re)launches the process against the same data dir and waits for it
+// to become healthy.
+func (p *PluginProcess) Start(t testing.TB) {
+	t.Helper()
+	if p.Running() {
+		t.Fatalf("plugin %s already running", p.opts.ID)
+	}
+
+	cmd := exec.Command(p.opts.Binary)
+	cmd.Env = append(os.Environ(),
+		"PLUGIN_ID="+p.opts.ID,
+		"PLUGIN_DATA_DIR="+p.opts.DataDir,
+	)
+	if url := NATSURL(); url != "" {
+		cmd.Env = append(cmd.Env, "NATS_URL="+url)
+	}
+	cmd.Env = append(cmd.Env, p.opts.Env...)
+	cmd.Stdout = p.logs
+	cmd.Stderr = p.logs
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start plugin %s (%s): %v", p.opts.ID, p.opts.Binary, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+	p.mu.Lock()
+	p.cmd, p.done = cmd, done
+	p.mu.Unlock()
+
+	if !WaitForPlugin(p.opts.ID, 15*time.Second) {
+		t.Fatalf("plugin %s did not become healthy after start", p.opts.ID)
+	}
+}
+
+// Kill sends SIGKILL, simulating a crash, and waits for the process to exit.
+func (p *PluginProcess) Kill() {
+	p.signal(syscall.SIGKILL, 0)
+}
+
+// Pause freezes the process with SIGSTOP so it stays registered but stops
+// answering; Resume undoes it.
+func (p *PluginProcess) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil && p.cmd.Process != nil {
+		_ = p.cmd.Process.Signal(syscall.SIGSTOP)
+	}
+}
+
+// Resume continues a paused process.
+func (p *PluginProcess) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil && p.cmd.Process != nil {
+		_ = p.cmd.Process.Signal(syscall.SIGCONT)
+	}
+}
+
+// Stop asks the process to exit with SIGTERM and kills it if it has not
+// exited within five seconds.
+func (p *PluginProcess) Stop() {
+	p.signal(syscall.SIGTERM, 5*time.Second)
+}
+
+func (p *PluginProcess) signal(sig syscall.Signal, grace time.Duration) {
+	p.mu.Lock()
+	cmd, done := p.cmd, p.done
+	p.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	select {
+	case <-done:
+		return
+	default:
+	}
+	// A paused process cannot act on SIGTERM.
+	_ = cmd.Process.Signal(syscall.SIGCONT)
+	_ = cmd.Process.Signal(sig)
+	if grace > 0 {
+		select {
+		case <-done:
+			return
+		case <-time.After(grace):
+			_ = cmd.Process.Kill()
+		}
+	}
+	<-done
+}
+
+// Logs returns everything the process has written to stdout and stderr.
+func (p *PluginProcess) Logs() string { return p.logs.String() }
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
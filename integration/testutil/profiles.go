@@ -0,0 +1,142 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Profile is one named plugin configuration: the environment a plugin is
+// launched with.
+type Profile struct {
+	Name string
+	Env  map[string]string
+}
+
+// Environ returns the profile's environment as KEY=VALUE pairs, sorted by key.
+func (p Profile) Environ() []string {
+	out := make([]string, 0, len(p.Env))
+	for k, v := range p.Env {
+		out = append(out, k+"="+v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ProfileResult is how a plugin's suite went under one profile.
+type ProfileResult struct {
+	Plugin   string        `json:"plugin"`
+	Profile  string        `json:"profile"`
+	Outcome  string        `json:"outcome"` // PASS, FAIL or SKIP
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// LoadProfiles reads every config/plugins/{id}/profiles/{name}.env file found
+// by the same upward search PluginEnv uses. The first directory that has any
+// profiles wins. TEST_PLUGIN_PROFILES, a comma-separated list of names,
+// limits which are returned.
+func LoadProfiles(pluginID string) ([]Profile, error) {
+	var profiles []Profile
+	for _, envPath := range findPluginEnvFiles(pluginID) {
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(envPath), "profiles", "*.env"))
+		if err != nil || len(matches) == 0 {
+			continue
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			env, err := parseDotEnvFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("profile %s: %w", path, err)
+			}
+			profiles = append(profiles, Profile{Name: strings.TrimSuffix(filepath.Base(path), ".env"), Env: env})
+		}
+		break
+	}
+	return filterProfiles(profiles), nil
+}
+
+func filterProfiles(profiles []Profile) []Profile {
+	only := strings.TrimSpace(os.Getenv("TEST_PLUGIN_PROFILES"))
+	if only == "" {
+		return profiles
+	}
+	want := map[string]bool{}
+	for _, name := range strings.Split(only, ",") {
+		want[strings.TrimSpace(name)] = true
+	}
+	out := profiles[:0:0]
+	for _, p := range profiles {
+		if want[p.Name] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// RunProfiles launches a harness-owned instance of pluginID once per profile,
+// with that profile's environment, and runs suite against it as a subtest
+// named after the profile. Each instance gets its own ID and data dir, so
+// profiles cannot leak into one another. When all have run, a per-profile
+// report is printed, written to TEST_REPORT_DIR/profiles-{id}.json if that is
+// set, and returned.
+func RunProfiles(t *testing.T, pluginID string, profiles []Profile, suite func(t *testing.T, p *PluginProcess, profile Profile)) []ProfileResult {
+	t.Helper()
+	profiles = filterProfiles(profiles)
+	if len(profiles) == 0 {
+		t.Skipf("no config profiles for %s", pluginID)
+	}
+	binary := PluginBinary(pluginID)
+
+	results := make([]ProfileResult, 0, len(profiles))
+	for _, profile := range profiles {
+		start := time.Now()
+		skipped := false
+		ok := t.Run(profile.Name, func(t *testing.T) {
+			defer func() { skipped = t.Skipped() }()
+			p := StartPlugin(t, PluginOptions{
+				Binary: binary,
+				ID:     pluginID + "-" + profile.Name,
+				Env:    profile.Environ(),
+			})
+			suite(t, p, profile)
+		})
+		outcome := "PASS"
+		switch {
+		case skipped:
+			outcome = "SKIP"
+		case !ok:
+			outcome = "FAIL"
+		}
+		results = append(results, ProfileResult{Plugin: pluginID, Profile: profile.Name, Outcome: outcome, Duration: time.Since(start)})
+	}
+
+	for _, r := range results {
+		fmt.Println(Redact(fmt.Sprintf("%s: %s profile %s (%s)", r.Outcome, pluginID, r.Profile, r.Duration.Round(time.Millisecond))))
+	}
+	if err := writeProfileReport(pluginID, results); err != nil {
+		t.Errorf("write profile report: %v", err)
+	}
+	return results
+}
+
+// writeProfileReport writes results to TEST_REPORT_DIR/profiles-{id}.json,
+// redacted. It does nothing when TEST_REPORT_DIR is unset.
+func writeProfileReport(pluginID string, results []ProfileResult) error {
+	dir := strings.TrimSpace(os.Getenv("TEST_REPORT_DIR"))
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "profiles-"+pluginID+".json"), []byte(Redact(string(data))+"\n"), 0o644)
+}
@@ -0,0 +1,48 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+var schemaCache = struct {
+	sync.Mutex
+	domains map[string]*types.DomainDescriptor
+}{domains: map[string]*types.DomainDescriptor{}}
+
+// DomainSchema fetches and caches /api/schema/domains/{domain}, reporting
+// false for a domain the gateway has no schema for. Only a 404 is cached as
+// "no schema"; any other failure is returned and retried on the next call.
+func DomainSchema(client http.Client, domain string) (types.DomainDescriptor, bool, error) {
+	schemaCache.Lock()
+	defer schemaCache.Unlock()
+	if desc, ok := schemaCache.domains[domain]; ok {
+		if desc == nil {
+			return types.DomainDescriptor{}, false, nil
+		}
+		return *desc, true, nil
+	}
+	var desc types.DomainDescriptor
+	url := APIBaseURL() + "/api/schema/domains/" + domain
+	resp, err := client.Get(url)
+	if err != nil {
+		return desc, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		schemaCache.domains[domain] = nil
+		return desc, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return desc, false, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return desc, false, fmt.Errorf("GET %s: %w", url, err)
+	}
+	schemaCache.domains[domain] = &desc
+	return desc, true, nil
+}
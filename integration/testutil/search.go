@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/slidebolt/sdk-types"
+)
+
+// SearchURL returns the gateway search route for kind ("plugins", "devices"
+// or "entities") with rawQuery appended verbatim.
+func SearchURL(kind, rawQuery string) string {
+	url := APIBaseURL() + "/api/search/" + kind
+	if rawQuery = strings.TrimPrefix(rawQuery, "?"); rawQuery != "" {
+		url += "?" + rawQuery
+	}
+	return url
+}
+
+// SearchDevices runs a device search and decodes the results. Any status
+// other than 200 is returned as an error.
+func SearchDevices(client http.Client, rawQuery string) ([]types.Device, error) {
+	var results []types.Device
+	if err := getJSON(client, SearchURL("devices", rawQuery), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SearchEntities runs an entity search and decodes the results. Any status
+// other than 200 is returned as an error.
+func SearchEntities(client http.Client, rawQuery string) ([]types.Entity, error) {
+	var results []types.Entity
+	if err := getJSON(client, SearchURL("entities", rawQuery), &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SearchStatus issues a search and returns only the status code, verifying
+// that a 200 body is a JSON array.
+func SearchStatus(client http.Client, kind, rawQuery string) (int, error) {
+	resp, err := client.Get(SearchURL(kind, rawQuery))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		var results []json.RawMessage
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			return resp.StatusCode, fmt.Errorf("200 response is not a JSON array: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,261 @@
+package testutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Redacted replaces secret values in anything passed through Redact.
+const Redacted = "[REDACTED]"
+
+// secretKeyParts are the key-name fragments that mark an env value as secret.
+var secretKeyParts = []string{"TOKEN", "PASSWORD", "PASSWD", "SECRET", "API_KEY", "APIKEY", "CREDENTIAL", "PRIVATE_KEY", "AUTHORIZATION"}
+
+// secretKeySuffixes mark a key as secret only at its end, so BASIC_AUTH is
+// one but AUTHOR and AUTH_MODE are not.
+var secretKeySuffixes = []string{"_AUTH", "_AUTH_KEY"}
+
+// urlUserinfo matches credentials embedded in a URL, e.g. the "user:pw@" in
+// mqtt://user:pw@broker:1883.
+var urlUserinfo = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/\s@:]+:[^/\s@]+@`)
+
+var secrets = struct {
+	sync.RWMutex
+	values map[string]struct{}
+}{values: map[string]struct{}{}}
+
+// IsSecretKey reports whether an env key or HTTP header names a secret: a
+// token, password, API key or other credential.
+func IsSecretKey(key string) bool {
+	upper := strings.ReplaceAll(strings.ToUpper(key), "-", "_")
+	if upper == "AUTH" {
+		return true
+	}
+	for _, part := range secretKeyParts {
+		if strings.Contains(upper, part) {
+			return true
+		}
+	}
+	for _, suffix := range secretKeySuffixes {
+		if strings.HasSuffix(upper, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterSecret marks the secret parts of value for redaction. The whole
+// value is registered if key names a secret; otherwise only the password and
+// userinfo of a URL are.
+func RegisterSecret(key, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	var found []string
+	if IsSecretKey(key) {
+		found = append(found, value)
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if pw, ok := u.User.Password(); ok && pw != "" {
+			found = append(found, pw, u.User.String())
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, s := range found {
+		// Very short values would redact unrelated text.
+		if len(s) >= 4 {
+			secrets.values[s] = struct{}{}
+		}
+	}
+}
+
+// RegisterSecretEnv registers every KEY=VALUE pair in env.
+func RegisterSecretEnv(env []string) {
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i > 0 {
+			RegisterSecret(kv[:i], kv[i+1:])
+		}
+	}
+}
+
+// RegisterPluginSecrets registers the process environment and every value in
+// every plugin's .env, .env.local and profiles/*.env under the first config
+// root found searching upward, the same files PluginEnv and LoadProfiles
+// read. A process that redacts reports after the tests have exited uses it
+// to learn the secrets those tests saw.
+func RegisterPluginSecrets() error {
+	RegisterSecretEnv(os.Environ())
+	root := findConfigRoot()
+	if root == "" {
+		return nil
+	}
+	var paths []string
+	for _, pattern := range []string{"*/.env", "*/.env.local", "*/profiles/*.env"} {
+		matches, err := filepath.Glob(filepath.Join(root, pattern))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	for _, path := range paths {
+		env, err := parseDotEnvFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for k, v := range env {
+			RegisterSecret(k, v)
+		}
+	}
+	return nil
+}
+
+// findConfigRoot returns TEST_PLUGIN_CONFIG_ROOT, or the nearest
+// config/plugins directory at or above the working directory.
+func findConfigRoot() string {
+	if root := strings.TrimSpace(os.Getenv("TEST_PLUGIN_CONFIG_ROOT")); root != "" {
+		return root
+	}
+	path, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < 8; i++ {
+		candidate := filepath.Join(path, "config", "plugins")
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate
+		}
+		next := filepath.Dir(path)
+		if next == path {
+			break
+		}
+		path = next
+	}
+	return ""
+}
+
+// Redact replaces every registered secret and any URL credentials in s.
+func Redact(s string) string {
+	secrets.RLock()
+	values := make([]string, 0, len(secrets.values))
+	for v := range secrets.values {
+		values = append(values, v)
+	}
+	secrets.RUnlock()
+	// Longest first, so a secret containing another is replaced whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, v := range values {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return urlUserinfo.ReplaceAllString(s, "${1}"+Redacted+"@")
+}
+
+// RedactHeader returns a copy of h with credential headers and secret values
+// redacted, for recording HTTP traffic.
+func RedactHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			if IsSecretKey(k) || strings.EqualFold(k, "Cookie") || strings.EqualFold(k, "Set-Cookie") {
+				v = Redacted
+			}
+			out.Add(k, Redact(v))
+		}
+	}
+	return out
+}
+
+// Logger is a testing.TB wrapper whose log and failure methods redact their
+// output. Use it anywhere a test prints values that may come from PluginEnv.
+type Logger struct {
+	testing.TB
+}
+
+// RedactingLogger wraps t.
+func RedactingLogger(t testing.TB) Logger { return Logger{t} }
+
+func (l Logger) Log(args ...any) {
+	l.TB.Helper()
+	l.TB.Log(Redact(fmt.Sprint(args...)))
+}
+
+func (l Logger) Logf(format string, args ...any) {
+	l.TB.Helper()
+	l.TB.Log(Redact(fmt.Sprintf(format, args...)))
+}
+
+func (l Logger) Error(args ...any) {
+	l.TB.Helper()
+	l.TB.Error(Redact(fmt.Sprint(args...)))
+}
+
+func (l Logger) Errorf(format string, args ...any) {
+	l.TB.Helper()
+	l.TB.Error(Redact(fmt.Sprintf(format, args...)))
+}
+
+func (l Logger) Fatal(args ...any) {
+	l.TB.Helper()
+	l.TB.Fatal(Redact(fmt.Sprint(args...)))
+}
+
+func (l Logger) Fatalf(format string, args ...any) {
+	l.TB.Helper()
+	l.TB.Fatal(Redact(fmt.Sprintf(format, args...)))
+}
+
+func (l Logger) Skip(args ...any) {
+	l.TB.Helper()
+	l.TB.Skip(Redact(fmt.Sprint(args...)))
+}
+
+func (l Logger) Skipf(format string, args ...any) {
+	l.TB.Helper()
+	l.TB.Skip(Redact(fmt.Sprintf(format, args...)))
+}
+
+// RedactStream copies r to w line by line, redacting each line. cmd/redact
+// runs `go test -json` output or a JUnit report through it before it is
+// stored.
+func RedactStream(w io.Writer, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if _, err := io.WriteString(w, Redact(sc.Text())+"\n"); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// RedactFile rewrites a report or artifact file in place with secrets
+// redacted.
+func RedactFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	redacted := Redact(string(data))
+	if redacted == string(data) {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(redacted), info.Mode().Perm())
+}
@@ -0,0 +1,334 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	entityswitch "github.com/slidebolt/sdk-entities/switch"
+	"github.com/slidebolt/sdk-types"
+)
+
+// stateDomains maps entity state types to the domain they belong to, so a
+// wait on the wrong kind of entity fails with a clear message instead of
+// decoding into zero values. Only switch has an sdk-entities package at the
+// pinned version; the sensor and camera types below are local stand-ins
+// with the same role until it grows them.
+var stateDomains = map[reflect.Type]string{
+	reflect.TypeOf(entityswitch.State{}): "switch",
+	reflect.TypeOf(SensorState{}):        "sensor",
+	reflect.TypeOf(CameraState{}):        "camera",
+}
+
+// SensorState is the reported state of a sensor entity, such as
+// plugin-system's system-cpu. sdk-entities has no sensor package at the
+// pinned version; switch to it once it does.
+type SensorState struct {
+	Percent float64 `json:"percent"`
+	TS      string  `json:"ts"`
+}
+
+// CameraState is the reported state of a camera entity, such as
+// plugin-frigate's frigate-entity-{camera}. sdk-entities has no camera
+// package at the pinned version; switch to it once it does.
+type CameraState struct {
+	StreamURL string `json:"stream_url"`
+}
+
+// StateTimeout is how long WaitForState and WaitForStateEqual poll,
+// overridable with TEST_STATE_TIMEOUT.
+func StateTimeout() time.Duration {
+	return EnvDuration("TEST_STATE_TIMEOUT", 10*time.Second)
+}
+
+// DecodeReported decodes an entity's Data.Reported into T. An entity with no
+// reported state yet returns an error.
+func DecodeReported[T any](e types.Entity) (T, error) {
+	return decodeState[T](e.Data.Reported)
+}
+
+// DecodeDesired decodes an entity's Data.Desired into T.
+func DecodeDesired[T any](e types.Entity) (T, error) {
+	return decodeState[T](e.Data.Desired)
+}
+
+func decodeState[T any](raw json.RawMessage) (T, error) {
+	var v T
+	if len(raw) == 0 || string(raw) == "null" {
+		return v, fmt.Errorf("no state")
+	}
+	err := json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// WaitForState polls an entity until its reported state, decoded into T,
+// satisfies pred, and returns that state. Every state seen is validated
+// against T with ValidateState. On timeout the test fails with the
+// distinct states observed, oldest first.
+func WaitForState[T any](t testing.TB, pluginID, deviceID, entityID string, pred func(T) bool) T {
+	t.Helper()
+	p := pollReported(t, pluginID, deviceID, entityID, pred)
+	if p.matched {
+		return p.last
+	}
+	msg := fmt.Sprintf("%s/%s/%s reported %T state never matched within %v", pluginID, deviceID, entityID, p.last, p.timeout)
+	if len(p.history) > 0 {
+		msg += "; states seen:\n  " + strings.Join(p.history, "\n  ")
+	} else if p.err != nil {
+		msg += ": " + p.err.Error()
+	}
+	t.Fatal(msg)
+	return p.last
+}
+
+// WaitForStateEqual waits for the reported state to equal want and, on
+// timeout, fails with a field-by-field diff against the last state seen.
+func WaitForStateEqual[T any](t testing.TB, pluginID, deviceID, entityID string, want T) T {
+	t.Helper()
+	p := pollReported(t, pluginID, deviceID, entityID, func(got T) bool { return reflect.DeepEqual(got, want) })
+	switch {
+	case p.matched:
+	case len(p.history) == 0:
+		t.Fatalf("%s/%s/%s never reported state within %v: %v", pluginID, deviceID, entityID, p.timeout, p.err)
+	default:
+		t.Fatalf("%s/%s/%s reported state did not converge within %v:\n  %s", pluginID, deviceID, entityID, p.timeout, strings.Join(StateDiff(want, p.last), "\n  "))
+	}
+	return p.last
+}
+
+// poll is the outcome of pollReported.
+type poll[T any] struct {
+	last    T
+	matched bool
+	history []string
+	err     error
+	timeout time.Duration
+}
+
+// pollReported polls an entity's reported state until done holds or
+// StateTimeout passes, validating each state against T.
+func pollReported[T any](t testing.TB, pluginID, deviceID, entityID string, done func(T) bool) poll[T] {
+	t.Helper()
+	client := http.Client{Timeout: 2 * time.Second}
+	p := poll[T]{timeout: StateTimeout()}
+	deadline := time.Now().Add(p.timeout)
+	for {
+		e, err := getEntity(client, pluginID, deviceID, entityID)
+		if err == nil {
+			checkStateDomain[T](t, e)
+			var state T
+			if state, err = DecodeReported[T](e); err == nil {
+				if verr := ValidateState[T](e.Domain, e.Data.Reported); verr != nil {
+					t.Fatalf("%s/%s/%s reported state %s: %v", pluginID, deviceID, entityID, e.Data.Reported, verr)
+				}
+				p.last = state
+				if done(state) {
+					p.matched = true
+					return p
+				}
+				p.history = appendHistory(p.history, state)
+			}
+		}
+		p.err = err
+		if time.Now().After(deadline) {
+			return p
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// StateDiff compares two states through their JSON form and returns one
+// "field: got X, want Y" line per differing top-level field.
+func StateDiff(want, got any) []string {
+	w, g := jsonFields(want), jsonFields(got)
+	keys := map[string]bool{}
+	for k := range w {
+		keys[k] = true
+	}
+	for k := range g {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	var out []string
+	for _, k := range sorted {
+		wv, wok := w[k]
+		gv, gok := g[k]
+		switch {
+		case !wok:
+			out = append(out, fmt.Sprintf("%s: got %s, want absent", k, gv))
+		case !gok:
+			out = append(out, fmt.Sprintf("%s: absent, want %s", k, wv))
+		case string(wv) != string(gv):
+			out = append(out, fmt.Sprintf("%s: got %s, want %s", k, gv, wv))
+		}
+	}
+	return out
+}
+
+func jsonFields(v any) map[string]json.RawMessage {
+	data, _ := json.Marshal(v)
+	out := map[string]json.RawMessage{}
+	if json.Unmarshal(data, &out) != nil {
+		out = map[string]json.RawMessage{"": data}
+	}
+	return out
+}
+
+// ValidateState checks a reported state payload for domain against the state
+// type T and the domain's schema from /api/schema/domains/{domain}. The
+// payload must be a JSON object, every field T declares without omitempty
+// must be present with the matching JSON type, and every field the schema's
+// events declare with a type must have that type where present. The schema
+// describes commands and events rather than state, so its event fields are
+// the only part that speaks to state; other fields are allowed.
+func ValidateState[T any](domain string, raw json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("not a JSON object: %w", err)
+	}
+	var zero T
+	var problems []string
+	if typ := reflect.TypeOf(zero); typ != nil && typ.Kind() == reflect.Struct {
+		for i := range typ.NumField() {
+			f := typ.Field(i)
+			name, omitempty, ok := jsonField(f)
+			if !ok {
+				continue
+			}
+			v, present := fields[name]
+			if !present {
+				if !omitempty {
+					problems = append(problems, fmt.Sprintf("missing field %q", name))
+				}
+				continue
+			}
+			if want := jsonKind(f.Type); want != "" && !jsonHasType(v, want) {
+				problems = append(problems, fmt.Sprintf("field %q is %s, want %s", name, v, want))
+			}
+		}
+	}
+	desc, ok, err := DomainSchema(http.Client{Timeout: 2 * time.Second}, domain)
+	if err != nil {
+		return fmt.Errorf("schema for %q: %w", domain, err)
+	}
+	if ok {
+		for _, ev := range desc.Events {
+			for _, f := range ev.Fields {
+				if v, present := fields[f.Name]; present && f.Type != "" && !jsonHasType(v, f.Type) {
+					problems = append(problems, fmt.Sprintf("field %q is %s, but %s event %q declares %s", f.Name, v, domain, ev.Action, f.Type))
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%T: %s", zero, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// jsonField returns the JSON name of an exported struct field and whether it
+// is omitempty; ok is false for fields encoding/json skips.
+func jsonField(f reflect.StructField) (name string, omitempty, ok bool) {
+	if !f.IsExported() {
+		return "", false, false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(opts, "omitempty"), true
+}
+
+// jsonKind is the JSON type a Go type encodes as, or "" if it may be null.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return "string"
+		}
+		return "object"
+	case reflect.Array:
+		return "array"
+	default:
+		// Pointers, slices, maps and interfaces may encode as null.
+		return ""
+	}
+}
+
+func jsonHasType(v json.RawMessage, typ string) bool {
+	s := strings.TrimSpace(string(v))
+	if s == "null" || s == "" {
+		return false
+	}
+	switch strings.ToLower(typ) {
+	case "bool", "boolean":
+		return s == "true" || s == "false"
+	case "number", "int", "integer", "float", "float64":
+		var n json.Number
+		return json.Unmarshal(v, &n) == nil
+	case "string":
+		return s[0] == '"'
+	case "object", "map":
+		return s[0] == '{'
+	case "array", "list":
+		return s[0] == '['
+	default:
+		return true
+	}
+}
+
+func checkStateDomain[T any](t testing.TB, e types.Entity) {
+	t.Helper()
+	var zero T
+	if domain, ok := stateDomains[reflect.TypeOf(zero)]; ok && e.Domain != domain {
+		t.Fatalf("entity %s/%s is domain %q, cannot decode %T (domain %q)", e.DeviceID, e.ID, e.Domain, zero, domain)
+	}
+}
+
+func getEntity(client http.Client, pluginID, deviceID, entityID string) (types.Entity, error) {
+	entities, err := ListEntities(client, pluginID, deviceID)
+	if err != nil {
+		return types.Entity{}, err
+	}
+	for _, e := range entities {
+		if e.ID == entityID {
+			return e, nil
+		}
+	}
+	return types.Entity{}, fmt.Errorf("entity %s/%s not listed", deviceID, entityID)
+}
+
+func appendHistory[T any](history []string, state T) []string {
+	data, _ := json.Marshal(state)
+	s := string(data)
+	if len(history) > 0 && history[len(history)-1] == s {
+		return history
+	}
+	history = append(history, s)
+	if len(history) > 5 {
+		history = history[len(history)-5:]
+	}
+	return history
+}
@@ -1,3 +1,8 @@
+// Package testutil is the shared harness for the integration suites. Values
+// read through PluginEnv may be secrets; tests print them only through
+// Logger, and the suite is run through cmd/redact so nothing else leaks:
+//
+//	go test -json ./... | go run ./cmd/redact > report.json
 package testutil
 
 import (
@@ -162,7 +167,7 @@ func RegisteredPlugins() (map[string]types.Registration, error) {
 	return registry, nil
 }
 
-func RequirePlugin(t *testing.T, id string) {
+func RequirePlugin(t testing.TB, id string) {
 	t.Helper()
 
 	var registry map[string]types.Registration
@@ -194,7 +199,7 @@ healthy:
 	}
 }
 
-func RequirePlugins(t *testing.T, ids ...string) {
+func RequirePlugins(t testing.TB, ids ...string) {
 	t.Helper()
 
 	var registry map[string]types.Registration
@@ -242,9 +247,13 @@ healthy:
 	}
 }
 
+// PluginEnv returns the first non-empty value of keys from the environment or
+// the plugin's .env files. Every value it reads is passed to RegisterSecret,
+// so secrets among them are redacted by Redact and Logger.
 func PluginEnv(pluginID string, keys ...string) string {
 	for _, key := range keys {
 		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			RegisterSecret(key, v)
 			return v
 		}
 	}
@@ -255,6 +264,7 @@ func PluginEnv(pluginID string, keys ...string) string {
 			continue
 		}
 		for k, v := range fileValues {
+			RegisterSecret(k, v)
 			if _, exists := values[k]; exists {
 				continue
 			}
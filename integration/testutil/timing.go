@@ -0,0 +1,123 @@
+package testutil
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// IntervalStats describes the spacing of a series of emitted timestamps
+// against an expected period, and how the emitter's clock tracked the
+// observer's.
+type IntervalStats struct {
+	Samples  int // number of timestamps
+	Expected time.Duration
+	Mean     time.Duration
+	Jitter   time.Duration // standard deviation of the intervals
+	Min      time.Duration
+	Max      time.Duration
+	// Drift is how far the ticks slipped behind (positive) or ran ahead of
+	// their schedule on the observer's wall clock: the observed span from
+	// the first sample to the last minus (Samples−1)×Expected. A steady
+	// per-tick slip accumulates; constant delivery latency cancels out.
+	Drift time.Duration
+}
+
+// TickSample is one timed event: when the emitter stamped it (for entity
+// events, CreatedAt) and when it was observed locally (BusEvent.ReceivedAt).
+type TickSample struct {
+	Emitted  time.Time
+	Observed time.Time
+}
+
+// TimingTolerance bounds acceptable IntervalStats.
+type TimingTolerance struct {
+	Mean   time.Duration // max |Mean - Expected|
+	Jitter time.Duration // max Jitter
+	Drift  time.Duration // max |Drift|
+}
+
+// AnalyzeTicks computes interval statistics over the emitted timestamps of
+// samples, which need not be sorted, and their cumulative drift from the
+// expected schedule in observation time.
+func AnalyzeTicks(samples []TickSample, expected time.Duration) IntervalStats {
+	ts := append([]TickSample(nil), samples...)
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Emitted.Before(ts[j].Emitted) })
+	s := IntervalStats{Samples: len(ts), Expected: expected}
+	if len(ts) < 2 {
+		return s
+	}
+
+	intervals := make([]time.Duration, len(ts)-1)
+	var sum time.Duration
+	for i := 1; i < len(ts); i++ {
+		d := ts[i].Emitted.Sub(ts[i-1].Emitted)
+		intervals[i-1] = d
+		sum += d
+		if i == 1 || d < s.Min {
+			s.Min = d
+		}
+		if d > s.Max {
+			s.Max = d
+		}
+	}
+	s.Mean = sum / time.Duration(len(intervals))
+
+	var variance float64
+	for _, d := range intervals {
+		diff := float64(d - s.Mean)
+		variance += diff * diff
+	}
+	s.Jitter = time.Duration(math.Sqrt(variance / float64(len(intervals))))
+
+	first, last := ts[0], ts[len(ts)-1]
+	s.Drift = last.Observed.Sub(first.Observed) - time.Duration(len(ts)-1)*expected
+	return s
+}
+
+// Check returns an error describing every bound in tol that s exceeds.
+func (s IntervalStats) Check(tol TimingTolerance) error {
+	if s.Samples < 2 {
+		return fmt.Errorf("need at least 2 samples, got %d", s.Samples)
+	}
+	var problems []string
+	if off := absDuration(s.Mean - s.Expected); off > tol.Mean {
+		problems = append(problems, fmt.Sprintf("mean interval %s is %s off expected %s (tolerance %s)", s.Mean, off, s.Expected, tol.Mean))
+	}
+	if s.Jitter > tol.Jitter {
+		problems = append(problems, fmt.Sprintf("jitter %s exceeds %s", s.Jitter, tol.Jitter))
+	}
+	if d := absDuration(s.Drift); d > tol.Drift {
+		problems = append(problems, fmt.Sprintf("cumulative drift %s from schedule over %d ticks exceeds %s", s.Drift, s.Samples-1, tol.Drift))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (s IntervalStats) String() string {
+	return fmt.Sprintf("n=%d mean=%s jitter=%s min=%s max=%s drift=%s",
+		s.Samples, s.Mean, s.Jitter, s.Min, s.Max, s.Drift)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// EnvDuration returns the duration in env var key, or def if it is unset or
+// unparseable.
+func EnvDuration(key string, def time.Duration) time.Duration {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
@@ -0,0 +1,362 @@
+// Package frigate is an in-process stand-in for a Frigate NVR and its bundled
+// go2rtc server. It models cameras, their detect/record toggles and fps
+// stats, go2rtc stream producers, detection events and snapshots, and lets
+// tests change any of it mid-run, inject HTTP errors or latency, and inspect
+// the requests a plugin made.
+package frigate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Camera is one camera as Frigate and go2rtc report it.
+type Camera struct {
+	Name       string
+	Enabled    bool
+	Detect     bool
+	Record     bool
+	CameraFPS  float64
+	ProcessFPS float64
+	// StreamURL is the go2rtc producer URL. Empty means the camera has no
+	// stream registered in go2rtc.
+	StreamURL string
+	// Snapshot is served for /api/{camera}/latest.jpg.
+	Snapshot []byte
+}
+
+// Event is a Frigate detection event.
+type Event struct {
+	ID          string  `json:"id"`
+	Camera      string  `json:"camera"`
+	Label       string  `json:"label"`
+	TopScore    float64 `json:"top_score"`
+	StartTime   float64 `json:"start_time"`
+	EndTime     float64 `json:"end_time,omitempty"`
+	HasSnapshot bool    `json:"has_snapshot"`
+	Snapshot    []byte  `json:"-"`
+}
+
+// Fault makes requests to a path fail or slow down.
+type Fault struct {
+	// Status, if non-zero, is returned instead of the normal response.
+	Status int
+	// Latency is added before responding.
+	Latency time.Duration
+	// Times limits the fault to the next N requests; 0 means until cleared.
+	Times int
+}
+
+// Request is a request the mock received.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	At     time.Time
+}
+
+// Server is a running mock. All methods are safe for concurrent use.
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	cameras  map[string]*Camera
+	events   []Event
+	faults   map[string]*Fault
+	requests []Request
+	filter   func(Request) Request
+}
+
+// NewServer starts a mock with the given cameras.
+func NewServer(cameras ...Camera) *Server {
+	s := &Server{
+		cameras: map[string]*Camera{},
+		faults:  map[string]*Fault{},
+	}
+	for _, c := range cameras {
+		s.AddCamera(c)
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// URL is the base URL to configure as both frigate_url and go2rtc_url.
+func (s *Server) URL() string { return s.srv.URL }
+
+// Close shuts the server down.
+func (s *Server) Close() { s.srv.Close() }
+
+// AddCamera adds or replaces a camera.
+func (s *Server) AddCamera(c Camera) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := c
+	s.cameras[c.Name] = &cp
+}
+
+// RemoveCamera removes a camera from config, stats and streams.
+func (s *Server) RemoveCamera(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.cameras, name)
+}
+
+// UpdateCamera applies fn to a camera and reports whether it exists.
+func (s *Server) UpdateCamera(name string, fn func(*Camera)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.cameras[name]
+	if ok {
+		fn(c)
+	}
+	return ok
+}
+
+// SetDetect toggles detection for a camera.
+func (s *Server) SetDetect(name string, on bool) bool {
+	return s.UpdateCamera(name, func(c *Camera) { c.Detect = on })
+}
+
+// SetRecord toggles recording for a camera.
+func (s *Server) SetRecord(name string, on bool) bool {
+	return s.UpdateCamera(name, func(c *Camera) { c.Record = on })
+}
+
+// Cameras returns the current camera names, sorted.
+func (s *Server) Cameras() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.cameras))
+	for name := range s.cameras {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddEvent records a detection event.
+func (s *Server) AddEvent(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+}
+
+// InjectFault applies f to every request whose path equals path, or to all
+// paths when path is "*".
+func (s *Server) InjectFault(path string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := f
+	s.faults[path] = &cp
+}
+
+// ClearFaults removes every injected fault.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[string]*Fault{}
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestCount returns how many requests were made to path.
+func (s *Server) RequestCount(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// SetRecordFilter passes every request through fn before it is recorded,
+// e.g. to redact credentials the plugin sent. Requests already recorded are
+// left alone.
+func (s *Server) SetRecordFilter(fn func(Request) Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = fn
+}
+
+// ResetRequests forgets recorded requests.
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Header: r.Header.Clone(), At: time.Now()}
+	s.mu.Lock()
+	if s.filter != nil {
+		rec = s.filter(rec)
+	}
+	s.requests = append(s.requests, rec)
+	fault := s.takeFault(r.URL.Path)
+	s.mu.Unlock()
+
+	if fault.Latency > 0 {
+		select {
+		case <-time.After(fault.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault.Status != 0 {
+		http.Error(w, http.StatusText(fault.Status), fault.Status)
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/api/config":
+		s.writeJSON(w, s.config())
+	case path == "/api/stats":
+		s.writeJSON(w, s.stats())
+	case path == "/api/streams":
+		s.writeJSON(w, s.streams())
+	case path == "/api/events":
+		s.writeJSON(w, s.listEvents(r.URL.Query().Get("camera")))
+	case strings.HasPrefix(path, "/api/events/") && strings.HasSuffix(path, "/snapshot.jpg"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/api/events/"), "/snapshot.jpg")
+		s.writeImage(w, s.eventSnapshot(id))
+	case strings.HasPrefix(path, "/api/") && strings.HasSuffix(path, "/latest.jpg"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "/api/"), "/latest.jpg")
+		s.writeImage(w, s.cameraSnapshot(name))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// takeFault returns the fault for path and consumes one use of it. Callers
+// hold s.mu.
+func (s *Server) takeFault(path string) Fault {
+	for _, key := range []string{path, "*"} {
+		f, ok := s.faults[key]
+		if !ok {
+			continue
+		}
+		out := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				delete(s.faults, key)
+			}
+		}
+		return out
+	}
+	return Fault{}
+}
+
+func (s *Server) config() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type toggle struct {
+		Enabled bool `json:"enabled"`
+	}
+	type camera struct {
+		Enabled bool   `json:"enabled"`
+		Name    string `json:"name"`
+		Detect  toggle `json:"detect"`
+		Record  toggle `json:"record"`
+	}
+	cameras := map[string]camera{}
+	for name, c := range s.cameras {
+		cameras[name] = camera{Enabled: c.Enabled, Name: c.Name, Detect: toggle{c.Detect}, Record: toggle{c.Record}}
+	}
+	return map[string]any{"cameras": cameras}
+}
+
+func (s *Server) stats() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type camera struct {
+		CameraFPS  float64 `json:"camera_fps"`
+		ProcessFPS float64 `json:"process_fps"`
+	}
+	cameras := map[string]camera{}
+	for name, c := range s.cameras {
+		cameras[name] = camera{CameraFPS: c.CameraFPS, ProcessFPS: c.ProcessFPS}
+	}
+	return map[string]any{"cameras": cameras}
+}
+
+func (s *Server) streams() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type producer struct {
+		URL        string `json:"url"`
+		RemoteAddr string `json:"remote_addr"`
+	}
+	type stream struct {
+		Producers []producer `json:"producers"`
+	}
+	out := map[string]stream{}
+	for name, c := range s.cameras {
+		if c.StreamURL == "" {
+			continue
+		}
+		out[name] = stream{Producers: []producer{{URL: c.StreamURL, RemoteAddr: "127.0.0.1"}}}
+	}
+	return out
+}
+
+func (s *Server) listEvents(camera string) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Event, 0, len(s.events))
+	for _, e := range s.events {
+		if camera == "" || e.Camera == camera {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (s *Server) eventSnapshot(id string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.ID == id && e.HasSnapshot {
+			return e.Snapshot
+		}
+	}
+	return nil
+}
+
+func (s *Server) cameraSnapshot(name string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.cameras[name]; ok {
+		return c.Snapshot
+	}
+	return nil
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) writeImage(w http.ResponseWriter, data []byte) {
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,123 @@
+package frigate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Step is one MQTT message in a scripted timeline, published At after the
+// replay starts.
+type Step struct {
+	At      time.Duration
+	Topic   string
+	Payload []byte
+}
+
+// Timeline is a sequence of Steps, replayed in order of At.
+type Timeline []Step
+
+// Publisher sends a message to the broker the plugin under test listens on.
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// EventMessage is the body Frigate publishes on frigate/events.
+type EventMessage struct {
+	Type   string `json:"type"` // new, update or end
+	Before Event  `json:"before"`
+	After  Event  `json:"after"`
+}
+
+// Motion publishes frigate/{camera}/motion as ON or OFF.
+func Motion(at time.Duration, camera string, on bool) Step {
+	state := "OFF"
+	if on {
+		state = "ON"
+	}
+	return Step{At: at, Topic: "frigate/" + camera + "/motion", Payload: []byte(state)}
+}
+
+// ObjectCount publishes frigate/{camera}/{object} with the number of objects
+// currently detected.
+func ObjectCount(at time.Duration, camera, object string, count int) Step {
+	return Step{At: at, Topic: "frigate/" + camera + "/" + object, Payload: []byte(strconv.Itoa(count))}
+}
+
+// EventNew, EventUpdate and EventEnd publish the frigate/events lifecycle for
+// e. EventEnd stamps EndTime if it is unset.
+func EventNew(at time.Duration, e Event) Step {
+	return eventStep(at, "new", e, e)
+}
+
+func EventUpdate(at time.Duration, before, after Event) Step {
+	return eventStep(at, "update", before, after)
+}
+
+func EventEnd(at time.Duration, e Event) Step {
+	after := e
+	if after.EndTime == 0 {
+		after.EndTime = after.StartTime + at.Seconds()
+	}
+	return eventStep(at, "end", e, after)
+}
+
+func eventStep(at time.Duration, typ string, before, after Event) Step {
+	data, _ := json.Marshal(EventMessage{Type: typ, Before: before, After: after})
+	return Step{At: at, Topic: "frigate/events", Payload: data}
+}
+
+// Replay publishes every step at its offset from now. It stops early if ctx
+// is cancelled or a publish fails.
+func (tl Timeline) Replay(ctx context.Context, pub Publisher) error {
+	start := time.Now()
+	for _, step := range tl {
+		if wait := time.Until(start.Add(step.At)); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		if err := pub.Publish(step.Topic, step.Payload); err != nil {
+			return fmt.Errorf("publish %s at %s: %w", step.Topic, step.At, err)
+		}
+	}
+	return nil
+}
+
+// MQTTPublisher publishes timeline steps to a real broker.
+type MQTTPublisher struct {
+	client mqtt.Client
+}
+
+// NewMQTTPublisher connects to brokerURL, e.g. tcp://127.0.0.1:1883.
+func NewMQTTPublisher(brokerURL, clientID string) (*MQTTPublisher, error) {
+	opts := mqtt.NewClientOptions().AddBroker(brokerURL).SetClientID(clientID)
+	client := mqtt.NewClient(opts)
+	tok := client.Connect()
+	if !tok.WaitTimeout(5 * time.Second) {
+		return nil, fmt.Errorf("connect %s: timeout", brokerURL)
+	}
+	if err := tok.Error(); err != nil {
+		return nil, fmt.Errorf("connect %s: %w", brokerURL, err)
+	}
+	return &MQTTPublisher{client: client}, nil
+}
+
+func (p *MQTTPublisher) Publish(topic string, payload []byte) error {
+	tok := p.client.Publish(topic, 0, false, payload)
+	if !tok.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("publish %s: timeout", topic)
+	}
+	return tok.Error()
+}
+
+// Close disconnects from the broker.
+func (p *MQTTPublisher) Close() {
+	p.client.Disconnect(250)
+}
@@ -0,0 +1,477 @@
+// Package mqtt is a minimal in-process MQTT 3.1.1 broker for tests. It
+// accepts any client, grants every subscription at QoS 0, keeps retained
+// messages, and lets tests publish directly, wait for a plugin to subscribe,
+// and inspect what clients published.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MQTT control packet types.
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// Message is a message a client published to the broker.
+type Message struct {
+	ClientID string
+	Topic    string
+	Payload  []byte
+	Retain   bool
+	At       time.Time
+}
+
+// Broker is a running broker. All methods are safe for concurrent use.
+type Broker struct {
+	ln net.Listener
+
+	mu        sync.Mutex
+	clients   map[*client]struct{}
+	retained  map[string][]byte
+	published []Message
+	changed   chan struct{} // closed and replaced whenever subscriptions change
+	wg        sync.WaitGroup
+}
+
+type client struct {
+	conn net.Conn
+	id   string
+
+	writeMu sync.Mutex
+	filters map[string]bool // guarded by Broker.mu
+}
+
+// NewBroker starts a broker on a free loopback port.
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		ln:       ln,
+		clients:  map[*client]struct{}{},
+		retained: map[string][]byte{},
+		changed:  make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// URL returns the broker address as tcp://host:port.
+func (b *Broker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// Close stops accepting connections and disconnects every client.
+func (b *Broker) Close() {
+	_ = b.ln.Close()
+	b.mu.Lock()
+	for c := range b.clients {
+		_ = c.conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+// Publish delivers a message to every subscribed client as if another client
+// had published it at QoS 0, without retaining it.
+func (b *Broker) Publish(topic string, payload []byte) error {
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	b.route(topic, payload)
+	return nil
+}
+
+// Subscribed reports whether any connected client holds a subscription
+// matching topic.
+func (b *Broker) Subscribed(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		for f := range c.filters {
+			if Match(f, topic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WaitSubscribed blocks until a client subscribes to a filter matching topic
+// or timeout passes, and reports which happened.
+func (b *Broker) WaitSubscribed(topic string, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		changed := b.changed
+		b.mu.Unlock()
+		if b.Subscribed(topic) {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// Filters returns every subscription filter held by a connected client.
+func (b *Broker) Filters() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []string
+	for c := range b.clients {
+		for f := range c.filters {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// Published returns a copy of every message clients have published.
+func (b *Broker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.published...)
+}
+
+// Match reports whether topic matches the subscription filter, honouring
+// the + and # wildcards. Topics starting with $ only match filters that
+// name their first level.
+func Match(filter, topic string) bool {
+	fl := strings.Split(filter, "/")
+	tl := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (fl[0] == "+" || fl[0] == "#") {
+		return false
+	}
+	for i, f := range fl {
+		if f == "#" {
+			return i == len(fl)-1
+		}
+		if i >= len(tl) {
+			return false
+		}
+		if f != "+" && f != tl[i] {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serve(conn)
+		}()
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	c := &client{conn: conn, filters: map[string]bool{}}
+	defer func() {
+		_ = conn.Close()
+		b.mu.Lock()
+		delete(b.clients, c)
+		b.notifyLocked()
+		b.mu.Unlock()
+	}()
+	r := bufio.NewReader(conn)
+
+	typ, _, body, err := readPacket(r)
+	if err != nil || typ != packetConnect {
+		return
+	}
+	id, err := parseConnect(body)
+	if err != nil {
+		return
+	}
+	c.id = id
+	if c.write(packetConnack<<4, []byte{0, 0}) != nil {
+		return
+	}
+	b.mu.Lock()
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+
+	for {
+		typ, flags, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case packetPublish:
+			if err := b.handlePublish(c, flags, body); err != nil {
+				return
+			}
+		case packetPubrel:
+			if len(body) < 2 || c.write(packetPubcomp<<4, body[:2]) != nil {
+				return
+			}
+		case packetSubscribe:
+			if err := b.handleSubscribe(c, body); err != nil {
+				return
+			}
+		case packetUnsubscribe:
+			if err := b.handleUnsubscribe(c, body); err != nil {
+				return
+			}
+		case packetPingreq:
+			if c.write(packetPingresp<<4, nil) != nil {
+				return
+			}
+		case packetPuback, packetPubrec, packetPubcomp:
+			// The broker only delivers at QoS 0; nothing to acknowledge.
+		case packetDisconnect:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (b *Broker) handlePublish(c *client, flags byte, body []byte) error {
+	qos := (flags >> 1) & 3
+	retain := flags&1 == 1
+	topic, rest, err := readString(body)
+	if err != nil {
+		return err
+	}
+	var packetID []byte
+	if qos > 0 {
+		if len(rest) < 2 {
+			return errors.New("publish: missing packet id")
+		}
+		packetID, rest = rest[:2], rest[2:]
+	}
+	payload := append([]byte(nil), rest...)
+
+	b.mu.Lock()
+	b.published = append(b.published, Message{ClientID: c.id, Topic: topic, Payload: payload, Retain: retain, At: time.Now()})
+	if retain {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = payload
+		}
+	}
+	b.mu.Unlock()
+	b.route(topic, payload)
+
+	switch qos {
+	case 1:
+		return c.write(packetPuback<<4, packetID)
+	case 2:
+		return c.write(packetPubrec<<4, packetID)
+	}
+	return nil
+}
+
+func (b *Broker) handleSubscribe(c *client, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("subscribe: missing packet id")
+	}
+	ack := append([]byte(nil), body[:2]...)
+	var filters []string
+	for rest := body[2:]; len(rest) > 0; {
+		filter, next, err := readString(rest)
+		if err != nil || len(next) < 1 {
+			return errors.New("subscribe: malformed filter")
+		}
+		filters = append(filters, filter)
+		ack = append(ack, 0) // granted QoS 0
+		rest = next[1:]
+	}
+	b.mu.Lock()
+	for _, f := range filters {
+		c.filters[f] = true
+	}
+	retained := map[string][]byte{}
+	for topic, payload := range b.retained {
+		for _, f := range filters {
+			if Match(f, topic) {
+				retained[topic] = payload
+			}
+		}
+	}
+	b.notifyLocked()
+	b.mu.Unlock()
+
+	if err := c.write(packetSuback<<4, ack); err != nil {
+		return err
+	}
+	for topic, payload := range retained {
+		if err := c.publish(topic, payload, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Broker) handleUnsubscribe(c *client, body []byte) error {
+	if len(body) < 2 {
+		return errors.New("unsubscribe: missing packet id")
+	}
+	b.mu.Lock()
+	for rest := body[2:]; len(rest) > 0; {
+		filter, next, err := readString(rest)
+		if err != nil {
+			b.mu.Unlock()
+			return err
+		}
+		delete(c.filters, filter)
+		rest = next
+	}
+	b.notifyLocked()
+	b.mu.Unlock()
+	return c.write(packetUnsuback<<4, body[:2])
+}
+
+// route sends a message to every client with a matching subscription.
+func (b *Broker) route(topic string, payload []byte) {
+	b.mu.Lock()
+	var targets []*client
+	for c := range b.clients {
+		for f := range c.filters {
+			if Match(f, topic) {
+				targets = append(targets, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, c := range targets {
+		if err := c.publish(topic, payload, false); err != nil {
+			_ = c.conn.Close()
+		}
+	}
+}
+
+func (b *Broker) notifyLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (c *client) publish(topic string, payload []byte, retain bool) error {
+	header := byte(packetPublish << 4)
+	if retain {
+		header |= 1
+	}
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return c.write(header, body)
+}
+
+func (c *client) write(header byte, body []byte) error {
+	buf := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		buf = append(buf, digit)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// readPacket reads one control packet and returns its type, flags and body.
+func readPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed remaining length")
+		}
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length += int(digit&0x7f) * mult
+		mult *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, body, nil
+}
+
+// parseConnect accepts MQTT 3.1 and 3.1.1 CONNECT packets and returns the
+// client ID. Will, username and password fields are read and ignored.
+func parseConnect(body []byte) (string, error) {
+	proto, rest, err := readString(body)
+	if err != nil {
+		return "", err
+	}
+	if proto != "MQTT" && proto != "MQIsdp" {
+		return "", fmt.Errorf("unsupported protocol %q", proto)
+	}
+	if len(rest) < 4 {
+		return "", errors.New("connect: short header")
+	}
+	id, _, err := readString(rest[4:])
+	return id, err
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("short string length")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("short string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}